@@ -0,0 +1,30 @@
+package http_endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// writeError responds with a structured 400 for DAG validation failures, a 404
+// for unknown runs, a 504 for timed out runs and falls back to a plain error
+// with the given status otherwise
+func writeError(w http.ResponseWriter, err error, status int) {
+	var validationErrs dag.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&map[string]interface{}{
+			"errors": validationErrs,
+		})
+		return
+	}
+	if errors.Is(err, dag.ErrRunNotFound) {
+		status = http.StatusNotFound
+	} else if dag.ErrorKindOf(err) == dag.ErrorTimeout {
+		status = http.StatusGatewayTimeout
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,127 @@
+package http_endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// keepAliveInterval is how often an idle event stream is pinged so proxies do
+// not close it
+const keepAliveInterval = 15 * time.Second
+
+var upgrader = websocket.Upgrader{
+	// origins are already restricted by the CORS configuration of the server
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// subscribe subscribes to the events of the run in the request path, writing
+// the error response itself when that fails
+func (h *RunnerHandler) subscribe(w http.ResponseWriter, r *http.Request) (<-chan dag.Event, func(), bool) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	events, unsubscribe, err := h.runnerService.Subscribe(r.Context(), runID)
+	if err != nil {
+		writeRunError(w, err)
+		return nil, nil, false
+	}
+	return events, unsubscribe, true
+}
+
+// StreamRunEvents streams the events of a run as Server-Sent Events until the
+// run finishes or the client goes away
+func (h *RunnerHandler) StreamRunEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe, ok := h.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// StreamRunEventsWS streams the events of a run as JSON messages over a
+// WebSocket until the run finishes or the client closes the connection
+func (h *RunnerHandler) StreamRunEventsWS(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe, ok := h.subscribe(w, r)
+	if !ok {
+		return
+	}
+	defer unsubscribe()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already written the error response
+		return
+	}
+	defer conn.Close()
+
+	// read until the client goes away so its close frame is noticed
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "run finished"))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		}
+	}
+}
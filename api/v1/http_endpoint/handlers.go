@@ -7,6 +7,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lynnphayu/dag-runner/internal/services/manager"
 	"github.com/lynnphayu/dag-runner/internal/services/runner"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
 )
 
 type RunnerHandler struct {
@@ -25,9 +26,10 @@ func (h *RunnerHandler) ExecuteDAGByID(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	dag, err := h.managerService.GetDAG(id)
+	dag, err := h.managerService.GetDAG(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	var input map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -35,14 +37,34 @@ func (h *RunnerHandler) ExecuteDAGByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.runnerService.Execute(dag, input)
+	h.execute(w, r, dag, input)
+}
+
+// PlanDAGByID responds with the SQL, HTTP requests and waves the stored DAG
+// would run for the input in the body, without executing it
+func (h *RunnerHandler) PlanDAGByID(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	dag, err := h.managerService.GetDAG(r.Context(), id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	var input map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := h.runnerService.Plan(dag, input)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(result)
+	json.NewEncoder(w).Encode(plan)
 }
 
 func (h *RunnerHandler) ExecuteDAG(w http.ResponseWriter, r *http.Request) {
@@ -52,19 +74,45 @@ func (h *RunnerHandler) ExecuteDAG(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.runnerService.Execute(&request.DAG, request.Input)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	h.execute(w, r, &request.DAG, request.Input)
+}
+
+// execute runs the DAG and writes its output, or the output together with the
+// step trace when the request asks for ?trace=true
+func (h *RunnerHandler) execute(w http.ResponseWriter, r *http.Request, d *dag.DAG, input map[string]interface{}) {
+	if r.URL.Query().Get("trace") != "true" {
+		result, err := h.runnerService.Execute(r.Context(), d, input)
+		if err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	result, err := h.runnerService.ExecuteWithTrace(r.Context(), d, input)
+	if err != nil && (result == nil || result.Trace == nil) {
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(&map[string]interface{}{
+			"error": err.Error(),
+			"trace": result.Trace,
+		})
+		return
+	}
 	json.NewEncoder(w).Encode(result)
 }
 
 func (h *RunnerHandler) GetTableNames(w http.ResponseWriter, r *http.Request) {
 
-	result, err := h.runnerService.GetTableNames()
+	result, err := h.runnerService.GetTableNames(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -80,7 +128,7 @@ func (h *RunnerHandler) GetColumns(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	tableName := vars["name"]
 
-	result, err := h.runnerService.GetColumns(tableName)
+	result, err := h.runnerService.GetColumns(r.Context(), tableName)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -91,17 +139,3 @@ func (h *RunnerHandler) GetColumns(w http.ResponseWriter, r *http.Request) {
 		"data": result,
 	})
 }
-
-// func (h *Handler) GetOperationStatus(w http.ResponseWriter, r *http.Request) {
-// 	vars := mux.Vars(r)
-// 	operationID := vars["operationId"]
-
-// 	status, err := h.executor.GetStatus(operationID)
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(status)
-// }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/lynnphayu/dag-runner/internal/services/manager"
@@ -27,19 +28,31 @@ func (h *ManagerHandler) SaveDAG(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.managerService.SaveDAG(&dag); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.managerService.SaveDAG(r.Context(), &dag); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+// GetDAG responds with the latest version of a DAG or the one asked for with
+// ?version=
 func (h *ManagerHandler) GetDAG(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	dag, err := h.managerService.GetDAG(id)
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid version", http.StatusBadRequest)
+			return
+		}
+		version = n
+	}
+
+	dag, err := h.managerService.LoadDAG(r.Context(), id, version)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -50,7 +63,7 @@ func (h *ManagerHandler) GetDAG(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *ManagerHandler) ListDAGs(w http.ResponseWriter, r *http.Request) {
-	dags, err := h.managerService.ListDAGs()
+	dags, err := h.managerService.ListDAGs(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -64,7 +77,7 @@ func (h *ManagerHandler) DeleteDAG(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
-	if err := h.managerService.DeleteDAG(id); err != nil {
+	if err := h.managerService.DeleteDAG(r.Context(), id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -84,8 +97,8 @@ func (h *ManagerHandler) UpdateDAG(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dag.ID = id
-	if result, err := h.managerService.UpdateDAG(&dag); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if result, err := h.managerService.UpdateDAG(r.Context(), &dag); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
 		return
 	} else {
 		w.Header().Set("Content-Type", "application/json")
@@ -6,6 +6,16 @@ import (
 
 func RegisterRoutes(router *mux.Router, runnerHandler *RunnerHandler, managerHandler *ManagerHandler) {
 	router.HandleFunc("/v1/dags/{id}/execute", runnerHandler.ExecuteDAGByID).Methods("POST")
+	router.HandleFunc("/v1/dags/{id}/plan", runnerHandler.PlanDAGByID).Methods("POST")
+	router.HandleFunc("/v1/dags/{id}/runs", runnerHandler.StartRun).Methods("POST")
+	router.HandleFunc("/v1/runs", runnerHandler.ListRuns).Methods("GET")
+	router.HandleFunc("/v1/runs/{runId}", runnerHandler.GetRun).Methods("GET")
+	router.HandleFunc("/v1/runs/{runId}/cancel", runnerHandler.CancelRun).Methods("POST")
+	router.HandleFunc("/v1/runs/{runId}/resume", runnerHandler.ResumeRun).Methods("POST")
+	router.HandleFunc("/v1/runs/{runId}/rerun", runnerHandler.RerunRun).Methods("POST")
+	router.HandleFunc("/v1/runs/{runId}/events", runnerHandler.StreamRunEvents).Methods("GET")
+	router.HandleFunc("/v1/runs/{runId}/events/ws", runnerHandler.StreamRunEventsWS).Methods("GET")
+	router.HandleFunc("/v1/runs/{runId}/result", runnerHandler.GetRunResult).Methods("GET")
 	router.HandleFunc("/v1/flows/execute", runnerHandler.ExecuteDAG).Methods("POST")
 	router.HandleFunc("/v1/tables", runnerHandler.GetTableNames).Methods("GET")
 	router.HandleFunc("/v1/tables/{name}", runnerHandler.GetColumns).Methods("GET")
@@ -0,0 +1,227 @@
+package http_endpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lynnphayu/dag-runner/internal/services/runner"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// StartRun starts an asynchronous run of a stored DAG and responds with the
+// run record straight away
+func (h *RunnerHandler) StartRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	d, err := h.managerService.GetDAG(r.Context(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var input map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	run, err := h.runnerService.StartRun(r.Context(), id, d, input)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/runs/"+run.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// GetRun responds with the status, step states and timings of a run
+func (h *RunnerHandler) GetRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	run, err := h.runnerService.GetRun(r.Context(), runID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+// CancelRun cancels a running run. The optional body {"cancelledBy": "..."}
+// records who asked for it.
+func (h *RunnerHandler) CancelRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	var request struct {
+		CancelledBy string `json:"cancelledBy"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if request.CancelledBy == "" {
+		request.CancelledBy = r.RemoteAddr
+	}
+
+	if err := h.runnerService.CancelRun(r.Context(), runID, request.CancelledBy); err != nil {
+		writeRunError(w, err)
+		return
+	}
+
+	run, err := h.runnerService.GetRun(r.Context(), runID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// ResumeRun continues a run that did not succeed from its checkpoints
+func (h *RunnerHandler) ResumeRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	run, err := h.runnerService.ResumeRun(r.Context(), runID)
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/runs/"+run.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// RerunRun starts a new run that re-runs the steps in the body
+// {"from": ["stepId"]} and their descendants with the current version of the
+// DAG, reusing the results of the other steps from the given run
+func (h *RunnerHandler) RerunRun(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	var request struct {
+		From []string `json:"from"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.From) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	previous, err := h.runnerService.GetRun(r.Context(), runID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	d := previous.DAG
+	if previous.DAGID != "" {
+		if d, err = h.managerService.GetDAG(r.Context(), previous.DAGID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if d == nil {
+		http.Error(w, fmt.Sprintf("run %s has no recorded DAG", runID), http.StatusConflict)
+		return
+	}
+
+	run, err := h.runnerService.RerunRun(r.Context(), runID, d, request.From)
+	if err != nil {
+		writeRunError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/runs/"+run.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// writeRunError responds with a conflict when a run is not in a state the
+// request can act on
+func writeRunError(w http.ResponseWriter, err error) {
+	if errors.Is(err, runner.ErrInvalidRerun) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, runner.ErrRunNotActive) || errors.Is(err, runner.ErrRunNotResumable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeError(w, err, http.StatusInternalServerError)
+}
+
+// GetRunResult responds with the output of a succeeded run, or a conflict
+// while the run has none
+func (h *RunnerHandler) GetRunResult(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	runID := vars["runId"]
+
+	run, err := h.runnerService.GetRun(r.Context(), runID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	switch run.Status {
+	case dag.RunSucceeded:
+	case dag.RunRunning:
+		http.Error(w, fmt.Sprintf("run %s is still running", run.ID), http.StatusConflict)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("run %s %s: %s", run.ID, run.Status, run.Error), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run.Output)
+}
+
+// ListRuns responds with the runs matching the dagId, status, from and to
+// query parameters. from and to are RFC 3339 timestamps bounding the creation time.
+func (h *RunnerHandler) ListRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := dag.RunFilter{
+		DAGID:  query.Get("dagId"),
+		Status: dag.RunStatus(query.Get("status")),
+	}
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, from); err != nil {
+			http.Error(w, "Invalid from timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, to); err != nil {
+			http.Error(w, "Invalid to timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+
+	runs, err := h.runnerService.ListRuns(r.Context(), filter)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&map[string]interface{}{
+		"data": runs,
+	})
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 
+	postgres "github.com/lynnphayu/dag-runner/internal/repositories/postgres"
 	"github.com/lynnphayu/dag-runner/internal/services/runner"
 	"github.com/lynnphayu/dag-runner/pkg/dag"
 	"github.com/spf13/cobra"
@@ -53,26 +61,27 @@ func main() {
 			if err != nil {
 				log.Fatalf("Failed to parse input as JSON: %v", err)
 			}
-			dagContent, err := os.ReadFile(dagFile)
-			if err != nil {
-				log.Fatalf("Failed to read DAG file: %v", err)
-			}
+			d := loadDAG(dagFile)
 
-			var dag dag.DAG
-			err = json.Unmarshal(dagContent, &dag)
-			if err != nil {
-				log.Fatalf("Failed to parse DAG file as JSON: %v", err)
-			}
+			stepTimeout, _ := cmd.Flags().GetDuration("step-timeout")
+			runTimeout, _ := cmd.Flags().GetDuration("run-timeout")
+			runnerService := runner.NewRunnerService(connStr, nil, dag.WithStepTimeout(stepTimeout), dag.WithRunTimeout(runTimeout))
 
-			runnerService := runner.NewRunnerService(connStr)
+			// cancel the run on Ctrl+C so in-flight steps are aborted
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer stop()
 
-			log.Println(dag, jsonData)
-			result, err := runnerService.Execute(&dag, jsonData)
+			log.Println(d, jsonData)
+			result, err := runnerService.ExecuteWithTrace(ctx, &d, jsonData)
+			if withTrace, _ := cmd.Flags().GetBool("trace"); withTrace && result != nil {
+				jsonTrace, _ := json.MarshalIndent(result.Trace, "", "  ")
+				log.Printf("Execution trace: %s", string(jsonTrace))
+			}
 			if err != nil {
 				log.Fatalf("Failed to execute DAG: %v", err)
 			}
 
-			jsonResult, err := json.Marshal(result)
+			jsonResult, err := json.Marshal(result.Output)
 			if err != nil {
 				log.Fatalf("Failed to marshal result to JSON: %v", err)
 			}
@@ -85,9 +94,132 @@ func main() {
 	startCmd.Flags().StringP("file", "f", "", "DAG json file to execute")
 	startCmd.Flags().StringP("postgres", "p", "", "Postgres connection string for db")
 	startCmd.Flags().StringP("input", "i", "", "Input json according to dag provided")
+	startCmd.Flags().Bool("trace", false, "Print the per-step execution trace")
+	startCmd.Flags().Duration("step-timeout", 0, "Default timeout for steps without their own timeout")
+	startCmd.Flags().Duration("run-timeout", 0, "Default timeout for the run when the DAG sets none")
+
+	// Validate DAG command
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check a DAG file for errors without executing it",
+		Run: func(cmd *cobra.Command, args []string) {
+			dagFile, err := cmd.Flags().GetString("file")
+			if err != nil {
+				log.Fatalf("Failed to get DAG file name: %v", err)
+			}
+			if dagFile == "" {
+				log.Fatal("DAG file name is required")
+			}
+			loadDAG(dagFile)
+			log.Println("DAG is valid")
+		},
+	}
+	validateCmd.Flags().StringP("file", "f", "", "DAG json file to validate")
+
+	// Plan DAG command
+	planCmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show the SQL, HTTP requests and waves a DAG would run without executing it",
+		Run: func(cmd *cobra.Command, args []string) {
+			dagFile, err := cmd.Flags().GetString("file")
+			if err != nil {
+				log.Fatalf("Failed to get DAG file name: %v", err)
+			}
+			if dagFile == "" {
+				log.Fatal("DAG file name is required")
+			}
+
+			input, _ := cmd.Flags().GetString("input")
+			jsonData := map[string]interface{}{}
+			if input != "" {
+				if err := json.Unmarshal([]byte(input), &jsonData); err != nil {
+					log.Fatalf("Failed to parse input as JSON: %v", err)
+				}
+			}
+			d := loadDAG(dagFile)
+
+			// planning only builds statements, so no connection is opened
+			executor, err := dag.NewExecutor(&postgres.Postgres{}, nil)
+			if err != nil {
+				log.Fatalf("Failed to create executor: %v", err)
+			}
+			plan, err := executor.Plan(&d, jsonData)
+			if err != nil {
+				log.Fatalf("Failed to plan DAG: %v", err)
+			}
+
+			jsonPlan, _ := json.MarshalIndent(plan, "", "  ")
+			fmt.Println(string(jsonPlan))
+		},
+	}
+	planCmd.Flags().StringP("file", "f", "", "DAG json file to plan")
+	planCmd.Flags().StringP("input", "i", "", "Input json according to dag provided")
+
+	// Cancel run command
+	cancelCmd := &cobra.Command{
+		Use:   "cancel <runId>",
+		Short: "Cancel a run started on a runner server",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			server, _ := cmd.Flags().GetString("server")
+			by, _ := cmd.Flags().GetString("by")
+
+			respBody, err := postRunAction(server, args[0], "cancel", map[string]string{"cancelledBy": by})
+			if err != nil {
+				log.Fatalf("Failed to cancel run: %v", err)
+			}
+			log.Printf("Cancellation requested: %s", respBody)
+		},
+	}
+	cancelCmd.Flags().StringP("server", "s", "http://localhost:8080", "Base URL of the runner server")
+	cancelCmd.Flags().String("by", os.Getenv("USER"), "Who is cancelling the run")
+
+	// Resume run command
+	resumeCmd := &cobra.Command{
+		Use:   "resume <runId>",
+		Short: "Resume a run on a runner server, skipping the steps that already succeeded",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			server, _ := cmd.Flags().GetString("server")
+
+			respBody, err := postRunAction(server, args[0], "resume", nil)
+			if err != nil {
+				log.Fatalf("Failed to resume run: %v", err)
+			}
+			log.Printf("Run resumed: %s", respBody)
+		},
+	}
+	resumeCmd.Flags().StringP("server", "s", "http://localhost:8080", "Base URL of the runner server")
+
+	// Re-run part of a run command
+	rerunCmd := &cobra.Command{
+		Use:   "rerun <runId>",
+		Short: "Re-run steps of a run and their descendants, reusing the results of the other steps",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			server, _ := cmd.Flags().GetString("server")
+			from, _ := cmd.Flags().GetStringSlice("from")
+			if len(from) == 0 {
+				log.Fatal("At least one step to re-run from is required")
+			}
+
+			respBody, err := postRunAction(server, args[0], "rerun", map[string][]string{"from": from})
+			if err != nil {
+				log.Fatalf("Failed to re-run: %v", err)
+			}
+			log.Printf("Re-run started: %s", respBody)
+		},
+	}
+	rerunCmd.Flags().StringP("server", "s", "http://localhost:8080", "Base URL of the runner server")
+	rerunCmd.Flags().StringSlice("from", nil, "Steps to re-run together with their descendants")
 
 	// Add commands to root
 	rootCmd.AddCommand(startCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(planCmd)
+	rootCmd.AddCommand(cancelCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(rerunCmd)
 
 	// Execute CLI
 	if err := rootCmd.Execute(); err != nil {
@@ -95,3 +227,49 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadDAG reads and statically validates a DAG file, exiting with the
+// validation errors as JSON if it is not runnable
+func loadDAG(path string) dag.DAG {
+	dagContent, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read DAG file: %v", err)
+	}
+
+	var d dag.DAG
+	err = json.Unmarshal(dagContent, &d)
+	if err != nil {
+		log.Fatalf("Failed to parse DAG file as JSON: %v", err)
+	}
+
+	if errs := dag.Validate(&d); len(errs) > 0 {
+		jsonErrs, _ := json.MarshalIndent(map[string]interface{}{"errors": errs}, "", "  ")
+		log.Fatalf("DAG validation failed:\n%s", string(jsonErrs))
+	}
+	return d
+}
+
+// postRunAction posts an action such as cancel or resume for a run to a
+// runner server and returns the response body
+func postRunAction(server string, runID string, action string, body interface{}) (string, error) {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	resp, err := http.Post(strings.TrimRight(server, "/")+"/v1/runs/"+runID+"/"+action, "application/json", reqBody)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return string(respBody), nil
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/lynnphayu/dag-runner/api/v1/http_endpoint"
+	postgres "github.com/lynnphayu/dag-runner/internal/repositories/postgres"
 	"github.com/lynnphayu/dag-runner/internal/services/manager"
 	"github.com/lynnphayu/dag-runner/internal/services/runner"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
 	"github.com/rs/cors"
 )
 
@@ -27,8 +33,68 @@ func main() {
 		log.Fatalf("missing MONGO_URI environment variable")
 	}
 
-	runnerService := runner.NewRunnerService(connStr)
+	var executorOpts []dag.ExecutorOption
+	if stepTimeout := os.Getenv("STEP_TIMEOUT"); stepTimeout != "" {
+		d, err := time.ParseDuration(stepTimeout)
+		if err != nil {
+			log.Fatalf("invalid STEP_TIMEOUT: %v", err)
+		}
+		executorOpts = append(executorOpts, dag.WithStepTimeout(d))
+	}
+	if runTimeout := os.Getenv("RUN_TIMEOUT"); runTimeout != "" {
+		d, err := time.ParseDuration(runTimeout)
+		if err != nil {
+			log.Fatalf("invalid RUN_TIMEOUT: %v", err)
+		}
+		executorOpts = append(executorOpts, dag.WithRunTimeout(d))
+	}
+	if maxParallel := os.Getenv("MAX_PARALLEL_STEPS"); maxParallel != "" {
+		n, err := strconv.Atoi(maxParallel)
+		if err != nil {
+			log.Fatalf("invalid MAX_PARALLEL_STEPS: %v", err)
+		}
+		executorOpts = append(executorOpts, dag.WithMaxParallelSteps(n))
+	}
+	if maxParallel := os.Getenv("MAX_PARALLEL_STEPS_PER_RUN"); maxParallel != "" {
+		n, err := strconv.Atoi(maxParallel)
+		if err != nil {
+			log.Fatalf("invalid MAX_PARALLEL_STEPS_PER_RUN: %v", err)
+		}
+		executorOpts = append(executorOpts, dag.WithMaxParallelStepsPerRun(n))
+	}
+	// RESOURCE_POOLS=db=10,http:api.partner.com=4
+	if pools := os.Getenv("RESOURCE_POOLS"); pools != "" {
+		for _, pool := range strings.Split(pools, ",") {
+			i := strings.LastIndex(pool, "=")
+			size, err := strconv.Atoi(pool[i+1:])
+			if i < 0 || err != nil {
+				log.Fatalf("invalid RESOURCE_POOLS entry %q, expected name=size", pool)
+			}
+			if size < 1 {
+				log.Fatalf("invalid RESOURCE_POOLS entry %q, the size must be at least 1", pool)
+			}
+			executorOpts = append(executorOpts, dag.WithResourcePool(strings.TrimSpace(pool[:i]), size))
+		}
+	}
+
 	managerService := manager.NewManagerService(mongoURI)
+	// steps referring to stored DAGs load them from the manager
+	executorOpts = append(executorOpts, dag.WithDAGLoader(managerService))
+
+	pg, err := postgres.NewPostgres(connStr)
+	if err != nil {
+		log.Fatalf("failed to create postgres: %v", err)
+	}
+	// runs are kept next to the DAGs in MongoDB unless RUN_STORE=postgres
+	var runStore dag.RunStore = managerService.RunStore()
+	if os.Getenv("RUN_STORE") == "postgres" {
+		store, err := pg.RunStore(context.Background())
+		if err != nil {
+			log.Fatalf("failed to create postgres run store: %v", err)
+		}
+		runStore = store
+	}
+	runnerService := runner.NewRunnerServiceWithDB(pg, runStore, executorOpts...)
 
 	router := mux.NewRouter()
 	runner := http_endpoint.NewRunnerHandler(runnerService, managerService)
@@ -2,6 +2,7 @@ package respositories
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -61,27 +62,27 @@ func (r *Http) buildHeaders(headers map[string]string) http.Header {
 	return reqHeaders
 }
 
-func (r *Http) Get(path string, query map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
-	return r.execute(http.MethodGet, path, query, nil, headers)
+func (r *Http) Get(ctx context.Context, path string, query map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+	return r.execute(ctx, http.MethodGet, path, query, nil, headers)
 }
 
-func (r *Http) Post(path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
-	return r.execute(http.MethodPost, path, query, body, headers)
+func (r *Http) Post(ctx context.Context, path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+	return r.execute(ctx, http.MethodPost, path, query, body, headers)
 }
 
-func (r *Http) Put(path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
-	return r.execute(http.MethodPut, path, query, body, headers)
+func (r *Http) Put(ctx context.Context, path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+	return r.execute(ctx, http.MethodPut, path, query, body, headers)
 }
 
-func (r *Http) Delete(path string, query map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
-	return r.execute(http.MethodDelete, path, query, nil, headers)
+func (r *Http) Delete(ctx context.Context, path string, query map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+	return r.execute(ctx, http.MethodDelete, path, query, nil, headers)
 }
 
-func (r *Http) Patch(path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
-	return r.execute(http.MethodPatch, path, query, body, headers)
+func (r *Http) Patch(ctx context.Context, path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+	return r.execute(ctx, http.MethodPatch, path, query, body, headers)
 }
 
-func (r *Http) execute(method string, path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
+func (r *Http) execute(ctx context.Context, method string, path string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*dag.ParsedResponse, error) {
 	// Build the request URL
 	parsedURL, err := r.buildRequestURL(method, path, query)
 	if err != nil {
@@ -94,7 +95,7 @@ func (r *Http) execute(method string, path string, query map[string]interface{},
 
 	}
 	reqHeaders := r.buildHeaders(headers)
-	req, err := http.NewRequest(method, parsedURL.String(), bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, method, parsedURL.String(), bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -0,0 +1,235 @@
+package repositories
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// translateFilter turns the where syntax of the query steps, also understood
+// by the Postgres query builder, into a Mongo filter: $and and $or take a
+// list of conditions, $not negates conditions and a field maps to the value
+// it must equal or to operators. Unknown operators are an error.
+func translateFilter(where map[string]interface{}) (bson.M, error) {
+	keys := make([]string, 0, len(where))
+	for key := range where {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]bson.M, 0, len(keys))
+	for _, key := range keys {
+		value := where[key]
+		switch key {
+		case "$and", "$or":
+			list, ok := toList(value)
+			if !ok || len(list) == 0 {
+				return nil, fmt.Errorf("%s takes a non-empty list of conditions", key)
+			}
+			translated := make(bson.A, len(list))
+			for i, item := range list {
+				nested, ok := item.(map[string]interface{})
+				if !ok || len(nested) == 0 {
+					return nil, fmt.Errorf("%s[%d] must be a non-empty object of conditions", key, i)
+				}
+				filter, err := translateFilter(nested)
+				if err != nil {
+					return nil, err
+				}
+				translated[i] = filter
+			}
+			conditions = append(conditions, bson.M{key: translated})
+		case "$not":
+			nested, ok := value.(map[string]interface{})
+			if !ok || len(nested) == 0 {
+				return nil, fmt.Errorf("$not takes a non-empty object of conditions")
+			}
+			filter, err := translateFilter(nested)
+			if err != nil {
+				return nil, err
+			}
+			// $not only applies to a single field in Mongo
+			conditions = append(conditions, bson.M{"$nor": bson.A{filter}})
+		default:
+			if strings.HasPrefix(key, "$") {
+				return nil, fmt.Errorf("unsupported where combinator %q", key)
+			}
+			translated, err := translateField(key, value)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, translated...)
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		return bson.M{}, nil
+	case 1:
+		return conditions[0], nil
+	default:
+		all := make(bson.A, len(conditions))
+		for i, condition := range conditions {
+			all[i] = condition
+		}
+		return bson.M{"$and": all}, nil
+	}
+}
+
+// comparisons maps the operators taking a single value to Mongo operators
+var comparisons = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+}
+
+// translateField returns the conditions on a single field
+func translateField(field string, value interface{}) ([]bson.M, error) {
+	operators, ok := value.(map[string]interface{})
+	if !ok {
+		return []bson.M{{field: bson.M{"$eq": value}}}, nil
+	}
+	if len(operators) == 0 {
+		return nil, fmt.Errorf("no operator given for %s", field)
+	}
+	ops := make([]string, 0, len(operators))
+	for op := range operators {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	conditions := make([]bson.M, 0, len(ops))
+	for _, op := range ops {
+		value := operators[op]
+		if operator, ok := comparisons[op]; ok {
+			conditions = append(conditions, bson.M{field: bson.M{operator: value}})
+			continue
+		}
+
+		switch op {
+		case "in", "notin", "contains", "containedBy", "overlaps", "between":
+			list, ok := toList(value)
+			if op == "contains" && !ok {
+				// jsonb containment of an object: every given key matches
+				object, isObject := value.(map[string]interface{})
+				if !isObject {
+					return nil, fmt.Errorf("contains on %s takes a list or an object", field)
+				}
+				for key, item := range object {
+					conditions = append(conditions, bson.M{field + "." + key: item})
+				}
+				continue
+			}
+			if !ok {
+				return nil, fmt.Errorf("%s on %s takes a list", op, field)
+			}
+			switch op {
+			case "in":
+				conditions = append(conditions, bson.M{field: bson.M{"$in": list}})
+			case "notin":
+				conditions = append(conditions, bson.M{field: bson.M{"$nin": list}})
+			case "contains":
+				conditions = append(conditions, bson.M{field: bson.M{"$all": list}})
+			case "containedBy":
+				// no element outside the list
+				conditions = append(conditions, bson.M{field: bson.M{"$not": bson.M{"$elemMatch": bson.M{"$nin": list}}}})
+			case "overlaps":
+				conditions = append(conditions, bson.M{field: bson.M{"$in": list}})
+			case "between":
+				if len(list) != 2 {
+					return nil, fmt.Errorf("between on %s takes a list of two bounds", field)
+				}
+				conditions = append(conditions, bson.M{field: bson.M{"$gte": list[0], "$lte": list[1]}})
+			}
+		case "like", "ilike":
+			pattern, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s on %s takes a string", op, field)
+			}
+			regex := bson.M{"$regex": likeToRegex(pattern)}
+			if op == "ilike" {
+				regex["$options"] = "i"
+			}
+			conditions = append(conditions, bson.M{field: regex})
+		case "null":
+			isNull, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("null on %s takes true or false", field)
+			}
+			if isNull {
+				conditions = append(conditions, bson.M{field: bson.M{"$eq": nil}})
+			} else {
+				conditions = append(conditions, bson.M{field: bson.M{"$ne": nil}})
+			}
+		case "hasKey":
+			key, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("hasKey on %s takes a string", field)
+			}
+			conditions = append(conditions, bson.M{field + "." + key: bson.M{"$exists": true}})
+		case "jsonPath", "jsonMatch":
+			return nil, fmt.Errorf("where operator %q is not supported by MongoDB", op)
+		default:
+			return nil, fmt.Errorf("unsupported where operator %q on %s", op, field)
+		}
+	}
+	return conditions, nil
+}
+
+// keyset selects the documents sorting after the values of page.After:
+// {$or: [{a: {$gt: v0}}, {a: v0, b: {$lt: v1}}, ...]}
+func keyset(page *dag.Page) bson.M {
+	alternatives := make(bson.A, len(page.OrderBy))
+	for i, order := range page.OrderBy {
+		alternative := bson.M{}
+		for j := 0; j < i; j++ {
+			alternative[page.OrderBy[j].Column] = page.After[j]
+		}
+		operator := "$gt"
+		if order.Desc {
+			operator = "$lt"
+		}
+		alternative[order.Column] = bson.M{operator: page.After[i]}
+		alternatives[i] = alternative
+	}
+	return bson.M{"$or": alternatives}
+}
+
+// likeToRegex turns a SQL LIKE pattern into an anchored regular expression
+func likeToRegex(pattern string) string {
+	var regex strings.Builder
+	regex.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			regex.WriteString(".*")
+		case '_':
+			regex.WriteString(".")
+		default:
+			regex.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	regex.WriteString("$")
+	return regex.String()
+}
+
+// toList returns the items of a slice of any element type
+func toList(value interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
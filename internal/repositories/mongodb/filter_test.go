@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTranslateFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		where  map[string]interface{}
+		filter bson.M
+		err    string
+	}{
+		{name: "empty", where: map[string]interface{}{}, filter: bson.M{}},
+		{name: "equality", where: map[string]interface{}{"id": 1}, filter: bson.M{"id": bson.M{"$eq": 1}}},
+		{
+			name:  "several fields",
+			where: map[string]interface{}{"id": 1, "status": "new"},
+			filter: bson.M{"$and": bson.A{
+				bson.M{"id": bson.M{"$eq": 1}},
+				bson.M{"status": bson.M{"$eq": "new"}},
+			}},
+		},
+		{
+			name:  "comparisons",
+			where: map[string]interface{}{"age": map[string]interface{}{"gte": 18, "lt": 65}},
+			filter: bson.M{"$and": bson.A{
+				bson.M{"age": bson.M{"$gte": 18}},
+				bson.M{"age": bson.M{"$lt": 65}},
+			}},
+		},
+		{
+			name:   "lists",
+			where:  map[string]interface{}{"id": map[string]interface{}{"notin": []int{1, 2}}},
+			filter: bson.M{"id": bson.M{"$nin": []interface{}{1, 2}}},
+		},
+		{
+			name:   "between",
+			where:  map[string]interface{}{"score": map[string]interface{}{"between": []interface{}{0, 9}}},
+			filter: bson.M{"score": bson.M{"$gte": 0, "$lte": 9}},
+		},
+		{
+			name:   "like",
+			where:  map[string]interface{}{"name": map[string]interface{}{"ilike": "te.st%_"}},
+			filter: bson.M{"name": bson.M{"$regex": `^te\.st.*.$`, "$options": "i"}},
+		},
+		{
+			name:   "null",
+			where:  map[string]interface{}{"email": map[string]interface{}{"null": false}},
+			filter: bson.M{"email": bson.M{"$ne": nil}},
+		},
+		{
+			name:   "hasKey",
+			where:  map[string]interface{}{"doc": map[string]interface{}{"hasKey": "k"}},
+			filter: bson.M{"doc.k": bson.M{"$exists": true}},
+		},
+		{
+			name: "combinators",
+			where: map[string]interface{}{
+				"$or":  []interface{}{map[string]interface{}{"status": "new"}, map[string]interface{}{"age": map[string]interface{}{"gte": 18}}},
+				"$not": map[string]interface{}{"name": "test"},
+			},
+			filter: bson.M{"$and": bson.A{
+				bson.M{"$nor": bson.A{bson.M{"name": bson.M{"$eq": "test"}}}},
+				bson.M{"$or": bson.A{bson.M{"status": bson.M{"$eq": "new"}}, bson.M{"age": bson.M{"$gte": 18}}}},
+			}},
+		},
+		{name: "unknown operator", where: map[string]interface{}{"a": map[string]interface{}{"regex": "x"}}, err: `unsupported where operator "regex"`},
+		{name: "unknown combinator", where: map[string]interface{}{"$xor": []interface{}{}}, err: `unsupported where combinator "$xor"`},
+		{name: "empty $and", where: map[string]interface{}{"$and": []interface{}{}}, err: "$and takes a non-empty list"},
+		{name: "jsonPath", where: map[string]interface{}{"doc": map[string]interface{}{"jsonPath": "$.a"}}, err: "not supported by MongoDB"},
+		{name: "between one bound", where: map[string]interface{}{"a": map[string]interface{}{"between": []int{1}}}, err: "takes a list of two bounds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := translateFilter(tt.where)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("translateFilter: %v", err)
+			}
+			if !reflect.DeepEqual(filter, tt.filter) {
+				t.Errorf("got %v, want %v", filter, tt.filter)
+			}
+		})
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	page := &dag.Page{OrderBy: []dag.Order{{Column: "ts"}, {Column: "id", Desc: true}}, After: []interface{}{"2024", 5}}
+	want := bson.M{"$or": bson.A{
+		bson.M{"ts": bson.M{"$gt": "2024"}},
+		bson.M{"ts": "2024", "id": bson.M{"$lt": 5}},
+	}}
+	if got := keyset(page); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -3,8 +3,10 @@ package repositories
 import (
 	"context"
 	"fmt"
+	"slices"
 	"time"
 
+	"github.com/lynnphayu/dag-runner/pkg/dag"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -12,6 +14,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// defaultOperationTimeout bounds operations whose context carries no deadline
+const defaultOperationTimeout = 5 * time.Second
+
 // MongoDB handles database operations for the DAG executor
 type MongoDB struct {
 	client *mongo.Client
@@ -48,31 +53,38 @@ func (r *MongoDB) Close() error {
 	return r.client.Disconnect(ctx)
 }
 
-// Create inserts a new document
-func (r *MongoDB) Create(collection string, data map[string]interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Create inserts a new document and returns the number of documents
+// inserted, like the Postgres repository, together with the returning fields
+// of the document and its _id when asked for
+func (r *MongoDB) Create(ctx context.Context, collection string, data map[string]interface{}, returning []string) (interface{}, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	result, err := r.db.Collection(collection).InsertOne(ctx, data)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert document: %w", err)
 	}
+	if len(returning) == 0 {
+		return int64(1), nil
+	}
 
-	return result.InsertedID, nil
+	// the document is stored as given, so there is nothing to read back
+	row := map[string]interface{}{"_id": result.InsertedID}
+	for field, value := range data {
+		if slices.Contains(returning, "*") || slices.Contains(returning, field) {
+			row[field] = value
+		}
+	}
+	return dag.MutationResult(1, []interface{}{row}), nil
 }
 
-// Retrieve fetches documents based on query
-func (r *MongoDB) Retrieve(collection string, fields []string, filter map[string]interface{}) ([]interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Retrieve fetches documents based on query, sorted and paged by page unless
+// it is nil
+func (r *MongoDB) Retrieve(ctx context.Context, collection string, fields []string, filter map[string]interface{}, page *dag.Page) ([]interface{}, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	// Build projection if specific fields are requested
-	projection := bson.M{}
-	if len(fields) > 0 && fields[0] != "*" {
-		for _, field := range fields {
-			projection[field] = 1
-		}
-	}
+	projection := projectFields(fields)
 
 	// Convert string _id to ObjectID if present
 	if idStr, ok := filter["_id"].(string); ok {
@@ -83,8 +95,41 @@ func (r *MongoDB) Retrieve(collection string, fields []string, filter map[string
 		filter["_id"] = objectID
 	}
 
+	query, err := translateFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetProjection(projection)
+	if page != nil {
+		if len(page.After) > 0 {
+			if len(query) == 0 {
+				query = keyset(page)
+			} else {
+				query = bson.M{"$and": bson.A{query, keyset(page)}}
+			}
+		}
+		if len(page.OrderBy) > 0 {
+			sort := make(bson.D, len(page.OrderBy))
+			for i, order := range page.OrderBy {
+				direction := 1
+				if order.Desc {
+					direction = -1
+				}
+				sort[i] = bson.E{Key: order.Column, Value: direction}
+			}
+			opts.SetSort(sort)
+		}
+		if page.Limit > 0 {
+			opts.SetLimit(int64(page.Limit))
+		}
+		if page.Offset > 0 {
+			opts.SetSkip(int64(page.Offset))
+		}
+	}
+
 	// Execute find operation
-	cursor, err := r.db.Collection(collection).Find(ctx, filter, options.Find().SetProjection(projection))
+	cursor, err := r.db.Collection(collection).Find(ctx, query, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute find: %w", err)
 	}
@@ -98,39 +143,127 @@ func (r *MongoDB) Retrieve(collection string, fields []string, filter map[string
 	return results, nil
 }
 
-// Update updates documents based on filter
-func (r *MongoDB) Update(collection string, update map[string]interface{}, filter map[string]interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Update updates documents based on filter and returns how many matched,
+// changed or not like the rows PostgreSQL counts, together with the returning
+// fields of the updated documents when asked for. Without transactions a
+// mutation bounded by maxAffectedRows is refused before anything changes.
+func (r *MongoDB) Update(ctx context.Context, collection string, update map[string]interface{}, filter map[string]interface{}, mutation *dag.Mutation) (interface{}, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
+	query, err := r.mutationQuery(ctx, collection, filter, mutation)
+	if err != nil {
+		return nil, err
+	}
 	result, err := r.db.Collection(collection).UpdateMany(
 		ctx,
-		filter,
+		query,
 		bson.M{"$set": update},
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update documents: %w", err)
 	}
-
-	return result.ModifiedCount, nil
+	if mutation == nil || len(mutation.Returning) == 0 {
+		return result.MatchedCount, nil
+	}
+	updated, err := r.documents(ctx, collection, query, mutation.Returning)
+	if err != nil {
+		return nil, err
+	}
+	return dag.MutationResult(result.MatchedCount, updated), nil
 }
 
-// Delete removes documents based on filter
-func (r *MongoDB) Delete(collection string, filter map[string]interface{}) (interface{}, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Delete removes documents based on filter and returns how many were
+// removed, together with the returning fields of the removed documents when
+// asked for
+func (r *MongoDB) Delete(ctx context.Context, collection string, filter map[string]interface{}, mutation *dag.Mutation) (interface{}, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
-	result, err := r.db.Collection(collection).DeleteMany(ctx, filter)
+	query, err := r.mutationQuery(ctx, collection, filter, mutation)
+	if err != nil {
+		return nil, err
+	}
+	var removed []interface{}
+	if mutation != nil && len(mutation.Returning) > 0 {
+		if removed, err = r.documents(ctx, collection, query, mutation.Returning); err != nil {
+			return nil, err
+		}
+	}
+	result, err := r.db.Collection(collection).DeleteMany(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to delete documents: %w", err)
 	}
-
+	if removed != nil {
+		return dag.MutationResult(result.DeletedCount, removed), nil
+	}
 	return result.DeletedCount, nil
 }
 
+// mutationQuery translates the filter of an update or delete. When the
+// mutation is bounded or returns documents, the matching documents are looked
+// up first and the query narrowed down to their _ids, so the documents
+// counted and returned are the ones changed.
+func (r *MongoDB) mutationQuery(ctx context.Context, collection string, filter map[string]interface{}, mutation *dag.Mutation) (bson.M, error) {
+	if err := mutation.CheckWhere(filter); err != nil {
+		return nil, err
+	}
+	query, err := translateFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if mutation == nil || (mutation.MaxAffectedRows == 0 && len(mutation.Returning) == 0) {
+		return query, nil
+	}
+
+	matching, err := r.documents(ctx, collection, query, []string{"_id"})
+	if err != nil {
+		return nil, err
+	}
+	if err := mutation.CheckAffected(int64(len(matching))); err != nil {
+		return nil, err
+	}
+	ids := make(bson.A, len(matching))
+	for i, document := range matching {
+		ids[i] = document.(map[string]interface{})["_id"]
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}, nil
+}
+
+// documents returns the given fields of the matching documents, always
+// including their _id, in the shape of rows
+func (r *MongoDB) documents(ctx context.Context, collection string, query bson.M, fields []string) ([]interface{}, error) {
+	cursor, err := r.db.Collection(collection).Find(ctx, query, options.Find().SetProjection(projectFields(fields)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute find: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var documents []bson.M
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, fmt.Errorf("failed to decode results: %w", err)
+	}
+	rows := make([]interface{}, len(documents))
+	for i, document := range documents {
+		rows[i] = map[string]interface{}(document)
+	}
+	return rows, nil
+}
+
+// projectFields selects the given fields, every field for none or "*"
+func projectFields(fields []string) bson.M {
+	projection := bson.M{}
+	if len(fields) > 0 && !slices.Contains(fields, "*") {
+		for _, field := range fields {
+			projection[field] = 1
+		}
+	}
+	return projection
+}
+
 // GetCollectionNames returns all collection names in the database
-func (r *MongoDB) GetCollectionNames() ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoDB) GetCollectionNames(ctx context.Context) ([]string, error) {
+	ctx, cancel := withDefaultTimeout(ctx)
 	defer cancel()
 
 	collections, err := r.db.ListCollectionNames(ctx, bson.M{})
@@ -140,3 +273,12 @@ func (r *MongoDB) GetCollectionNames() ([]string, error) {
 
 	return collections, nil
 }
+
+// withDefaultTimeout keeps the caller's deadline when there is one and only
+// falls back to defaultOperationTimeout for unbounded contexts
+func withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultOperationTimeout)
+}
@@ -2,86 +2,328 @@ package respositories
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
 )
 
-func BuildSelectQuery(table string, columns []string, where map[string]interface{}) (string, []interface{}) {
-	if len(columns) == 0 {
-		columns = append(columns, "*")
+// BuildSelectQuery constructs a SELECT query, sorted and paged by page unless
+// it is nil. A page with After only selects the rows sorting after it.
+func BuildSelectQuery(table string, columns []string, where map[string]interface{}, page *dag.Page) (string, []interface{}, error) {
+	selected := []string{"*"}
+	if len(columns) > 0 {
+		selected = quoteAll(columns)
 	}
 	base := fmt.Sprintf(
 		"SELECT %s FROM %s",
-		strings.Join(columns, ", "),
-		table,
+		strings.Join(selected, ", "),
+		quoteTable(table),
 	)
-	if len(where) != 0 {
-		whereClause, whereArgs := BuildWhereClause(table, where)
+
+	b := &whereBuilder{}
+	whereClause, err := b.where(where)
+	if err != nil {
+		return "", nil, err
+	}
+	if page != nil && len(page.After) > 0 {
+		if whereClause != "" {
+			whereClause += " AND "
+		}
+		whereClause += b.keyset(page)
+	}
+	if whereClause != "" {
 		base = fmt.Sprintf(
 			"%s WHERE %s",
 			base,
 			whereClause,
 		)
-		return base, whereArgs
 	}
-	return base, nil
+	if page == nil {
+		return base, b.args, nil
+	}
+
+	if len(page.OrderBy) > 0 {
+		orders := make([]string, len(page.OrderBy))
+		for i, order := range page.OrderBy {
+			orders[i] = quote(order.Column)
+			if order.Desc {
+				orders[i] += " DESC"
+			}
+		}
+		base = fmt.Sprintf("%s ORDER BY %s", base, strings.Join(orders, ", "))
+	}
+	if page.Limit > 0 {
+		base = fmt.Sprintf("%s LIMIT %d", base, page.Limit)
+	}
+	if page.Offset > 0 {
+		base = fmt.Sprintf("%s OFFSET %d", base, page.Offset)
+	}
+	return base, b.args, nil
 }
 
-// BuildWhereClause constructs a WHERE clause from the given conditions
-func BuildWhereClause(table string, conditions map[string]interface{}) (string, []interface{}) {
-	var clauses []string
-	var args []interface{}
-	var i int
-
-	for field, value := range conditions {
-		// Handle special operators
-		switch v := value.(type) {
-		case map[string]interface{}:
-			for op, val := range v {
-				switch op {
-				case "eq":
-					clauses = append(clauses, fmt.Sprintf("%s = $%d", field, i+1))
-					args = append(args, val)
-				case "gt":
-					clauses = append(clauses, fmt.Sprintf("%s > $%d", field, i+1))
-					args = append(args, val)
-				case "lt":
-					clauses = append(clauses, fmt.Sprintf("%s < $%d", field, i+1))
-					args = append(args, val)
-				case "gte":
-					clauses = append(clauses, fmt.Sprintf("%s >= $%d", field, i+1))
-					args = append(args, val)
-				case "lte":
-					clauses = append(clauses, fmt.Sprintf("%s <= $%d", field, i+1))
-					args = append(args, val)
-				case "like":
-					clauses = append(clauses, fmt.Sprintf("%s LIKE $%d", field, i+1))
-					args = append(args, val)
-				case "in":
-					if arr, ok := val.([]interface{}); ok {
-						placeholders := make([]string, len(arr))
-						for j := range arr {
-							placeholders[j] = fmt.Sprintf("$%d", i+j+1)
-							args = append(args, arr[j])
-						}
-						clauses = append(clauses, fmt.Sprintf("%s IN (%s)", field, strings.Join(placeholders, ",")))
-						i += len(arr) - 1
-					}
+// BuildWhereClause constructs a WHERE clause from the given conditions. Keys
+// are columns, all of which must match, or the combinators $and and $or,
+// taking a list of conditions, and $not, taking conditions to negate. A column
+// maps to the value it must equal, null for IS NULL, or to operators:
+//
+//	{"$or": [{"status": "new"}, {"age": {"gte": 18, "lt": 65}}], "$not": {"name": {"ilike": "test%"}}}
+//
+// Unknown operators are an error.
+func BuildWhereClause(table string, conditions map[string]interface{}) (string, []interface{}, error) {
+	b := &whereBuilder{}
+	clause, err := b.where(conditions)
+	if err != nil {
+		return "", nil, err
+	}
+	return clause, b.args, nil
+}
+
+// whereBuilder compiles conditions into SQL, numbering its placeholders after
+// the arguments already bound
+type whereBuilder struct {
+	args []interface{}
+}
+
+// bind adds an argument and returns its placeholder
+func (b *whereBuilder) bind(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *whereBuilder) where(conditions map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(conditions))
+	for key := range conditions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	clauses := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := conditions[key]
+		switch key {
+		case "$and", "$or":
+			list, ok := toList(value)
+			if !ok || len(list) == 0 {
+				return "", fmt.Errorf("%s takes a non-empty list of conditions", key)
+			}
+			parts := make([]string, len(list))
+			for i, item := range list {
+				nested, ok := item.(map[string]interface{})
+				if !ok || len(nested) == 0 {
+					return "", fmt.Errorf("%s[%d] must be a non-empty object of conditions", key, i)
+				}
+				part, err := b.where(nested)
+				if err != nil {
+					return "", err
 				}
-				i++
+				parts[i] = "(" + part + ")"
+			}
+			separator := " AND "
+			if key == "$or" {
+				separator = " OR "
 			}
+			clauses = append(clauses, "("+strings.Join(parts, separator)+")")
+		case "$not":
+			nested, ok := value.(map[string]interface{})
+			if !ok || len(nested) == 0 {
+				return "", fmt.Errorf("$not takes a non-empty object of conditions")
+			}
+			part, err := b.where(nested)
+			if err != nil {
+				return "", err
+			}
+			clauses = append(clauses, "NOT ("+part+")")
 		default:
+			if strings.HasPrefix(key, "$") {
+				return "", fmt.Errorf("unsupported where combinator %q", key)
+			}
+			clause, err := b.column(quote(key), value)
+			if err != nil {
+				return "", err
+			}
+			clauses = append(clauses, clause)
+		}
+	}
+	return strings.Join(clauses, " AND "), nil
+}
 
-			clauses = append(clauses, fmt.Sprintf("%s = $%d", field, i+1))
-			args = append(args, value)
-			i++
+// column compiles the conditions on a single column
+func (b *whereBuilder) column(field string, value interface{}) (string, error) {
+	operators, ok := value.(map[string]interface{})
+	if !ok {
+		return b.operator(field, "eq", value)
+	}
+	if len(operators) == 0 {
+		return "", fmt.Errorf("no operator given for %s", field)
+	}
+	ops := make([]string, 0, len(operators))
+	for op := range operators {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	clauses := make([]string, len(ops))
+	for i, op := range ops {
+		clause, err := b.operator(field, op, operators[op])
+		if err != nil {
+			return "", err
 		}
+		clauses[i] = clause
 	}
+	return strings.Join(clauses, " AND "), nil
+}
 
-	return strings.Join(clauses, " AND "), args
+// comparisons maps the operators taking a single argument to their SQL
+var comparisons = map[string]string{
+	"eq":          "=",
+	"ne":          "<>",
+	"gt":          ">",
+	"gte":         ">=",
+	"lt":          "<",
+	"lte":         "<=",
+	"like":        "LIKE",
+	"ilike":       "ILIKE",
+	"contains":    "@>", // array or jsonb containment
+	"containedBy": "<@",
+	"overlaps":    "&&",
+	"hasKey":      "?", // jsonb top-level key
 }
 
-// BuildInsertQuery constructs an INSERT query from the given parameters
-func BuildInsertQuery(table string, mapping map[string]interface{}) (string, []interface{}, error) {
+func (b *whereBuilder) operator(field, op string, value interface{}) (string, error) {
+	if sql, ok := comparisons[op]; ok {
+		switch {
+		case value == nil && op == "eq":
+			return fmt.Sprintf("%s IS NULL", field), nil
+		case value == nil && op == "ne":
+			return fmt.Sprintf("%s IS NOT NULL", field), nil
+		}
+		return fmt.Sprintf("%s %s %s", field, sql, b.bind(value)), nil
+	}
+
+	switch op {
+	case "in", "notin":
+		list, ok := toList(value)
+		if !ok {
+			return "", fmt.Errorf("%s on %s takes a list", op, field)
+		}
+		if len(list) == 0 {
+			// nothing is in an empty list
+			if op == "in" {
+				return "FALSE", nil
+			}
+			return "TRUE", nil
+		}
+		placeholders := make([]string, len(list))
+		for i, item := range list {
+			placeholders[i] = b.bind(item)
+		}
+		sql := "IN"
+		if op == "notin" {
+			sql = "NOT IN"
+		}
+		return fmt.Sprintf("%s %s (%s)", field, sql, strings.Join(placeholders, ",")), nil
+	case "between":
+		list, ok := toList(value)
+		if !ok || len(list) != 2 {
+			return "", fmt.Errorf("between on %s takes a list of two bounds", field)
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", field, b.bind(list[0]), b.bind(list[1])), nil
+	case "null":
+		isNull, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("null on %s takes true or false", field)
+		}
+		if isNull {
+			return fmt.Sprintf("%s IS NULL", field), nil
+		}
+		return fmt.Sprintf("%s IS NOT NULL", field), nil
+	case "jsonPath":
+		// the jsonb value has an item matching the SQL/JSON path
+		return fmt.Sprintf("%s @? %s::jsonpath", field, b.bind(value)), nil
+	case "jsonMatch":
+		// the SQL/JSON path predicate holds for the jsonb value
+		return fmt.Sprintf("%s @@ %s::jsonpath", field, b.bind(value)), nil
+	default:
+		return "", fmt.Errorf("unsupported where operator %q on %s", op, field)
+	}
+}
+
+// keyset selects the rows sorting after the values of page.After, with a row
+// comparison when every column sorts the same way
+func (b *whereBuilder) keyset(page *dag.Page) string {
+	sameDirection := true
+	for _, order := range page.OrderBy {
+		sameDirection = sameDirection && order.Desc == page.OrderBy[0].Desc
+	}
+	after := func(order dag.Order) string {
+		if order.Desc {
+			return "<"
+		}
+		return ">"
+	}
+
+	if sameDirection {
+		columns := make([]string, len(page.OrderBy))
+		placeholders := make([]string, len(page.OrderBy))
+		for i, order := range page.OrderBy {
+			columns[i] = quote(order.Column)
+			placeholders[i] = b.bind(page.After[i])
+		}
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), after(page.OrderBy[0]), strings.Join(placeholders, ", "))
+	}
+
+	// (a > $1) OR (a = $1 AND b < $2) ...
+	alternatives := make([]string, len(page.OrderBy))
+	for i, order := range page.OrderBy {
+		parts := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = %s", quote(page.OrderBy[j].Column), b.bind(page.After[j])))
+		}
+		parts = append(parts, fmt.Sprintf("%s %s %s", quote(order.Column), after(order), b.bind(page.After[i])))
+		alternatives[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// quote quotes a column name so it is never read as SQL, leaving * as it is
+func quote(column string) string {
+	if column == "*" {
+		return column
+	}
+	return pgx.Identifier{column}.Sanitize()
+}
+
+func quoteAll(columns []string) []string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quote(column)
+	}
+	return quoted
+}
+
+// quoteTable quotes a table name, qualified by its schema when it has a dot
+func quoteTable(table string) string {
+	return pgx.Identifier(strings.Split(table, ".")).Sanitize()
+}
+
+// toList returns the items of a slice of any element type
+func toList(value interface{}) ([]interface{}, bool) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// BuildInsertQuery constructs an INSERT query from the given parameters,
+// returning the given columns of the inserted row
+func BuildInsertQuery(table string, mapping map[string]interface{}, returning []string) (string, []interface{}, error) {
 	var columns []string
 	var placeholders []string
 	var args []interface{}
@@ -104,40 +346,72 @@ func BuildInsertQuery(table string, mapping map[string]interface{}) (string, []i
 
 	query := fmt.Sprintf(
 		"INSERT INTO %s (%s) VALUES %s",
-		table,
-		strings.Join(columns, ", "),
+		quoteTable(table),
+		strings.Join(quoteAll(columns), ", "),
 		strings.Join(placeholders, ", "),
 	)
 
-	return query, args, nil
+	return query + returningSQL(returning), args, nil
 }
 
-func BuildUpdateQuery(table string, mapping map[string]interface{}, where map[string]interface{}) (string, []interface{}) {
-	var setClauses []string
-	var args []interface{}
-	var i int
-	for field, value := range mapping {
-		setClauses = append(setClauses, fmt.Sprintf("%s = $%d", field, i+1))
-		args = append(args, value)
-		i++
-	}
-	whereClause, whereArgs := BuildWhereClause(table, where)
-	args = append(args, whereArgs...)
+// BuildUpdateQuery constructs an UPDATE query, the placeholders of the WHERE
+// clause continuing after those of the SET clause. An empty where is refused
+// unless the mutation allows every row.
+func BuildUpdateQuery(table string, mapping map[string]interface{}, where map[string]interface{}, mutation *dag.Mutation) (string, []interface{}, error) {
+	if err := mutation.CheckWhere(where); err != nil {
+		return "", nil, err
+	}
+	columns := make([]string, 0, len(mapping))
+	for field := range mapping {
+		columns = append(columns, field)
+	}
+	sort.Strings(columns)
+
+	b := &whereBuilder{}
+	setClauses := make([]string, len(columns))
+	for i, field := range columns {
+		setClauses[i] = fmt.Sprintf("%s = %s", quote(field), b.bind(mapping[field]))
+	}
+	whereClause, err := b.where(where)
+	if err != nil {
+		return "", nil, err
+	}
 	query := fmt.Sprintf(
-		"UPDATE %s SET %s WHERE %s",
-		table,
+		"UPDATE %s SET %s",
+		quoteTable(table),
 		strings.Join(setClauses, ", "),
-		whereClause,
 	)
-	return query, args
+	return query + whereSQL(whereClause) + returningSQL(returning(mutation)), b.args, nil
 }
 
-func BuildDeleteQuery(table string, where map[string]interface{}) (string, []interface{}) {
-	whereClause, whereArgs := BuildWhereClause(table, where)
+// BuildDeleteQuery constructs a DELETE query. An empty where is refused unless
+// the mutation allows every row.
+func BuildDeleteQuery(table string, where map[string]interface{}, mutation *dag.Mutation) (string, []interface{}, error) {
+	if err := mutation.CheckWhere(where); err != nil {
+		return "", nil, err
+	}
+	whereClause, whereArgs, err := BuildWhereClause(table, where)
+	if err != nil {
+		return "", nil, err
+	}
 	query := fmt.Sprintf(
-		"DELETE FROM %s WHERE %s",
-		table,
-		whereClause,
+		"DELETE FROM %s",
+		quoteTable(table),
 	)
-	return query, whereArgs
+	return query + whereSQL(whereClause) + returningSQL(returning(mutation)), whereArgs, nil
+}
+
+func whereSQL(clause string) string {
+	if clause == "" {
+		return ""
+	}
+	return " WHERE " + clause
+}
+
+// returningSQL returns the RETURNING clause of the given columns, if any
+func returningSQL(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return " RETURNING " + strings.Join(quoteAll(columns), ", ")
 }
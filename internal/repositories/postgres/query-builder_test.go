@@ -0,0 +1,201 @@
+package respositories
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+func TestBuildWhereClause(t *testing.T) {
+	tests := []struct {
+		name  string
+		where map[string]interface{}
+		sql   string
+		args  []interface{}
+		err   string
+	}{
+		{name: "empty", where: map[string]interface{}{}, sql: ""},
+		{
+			name:  "equality",
+			where: map[string]interface{}{"status": "new", "id": 1},
+			sql:   `"id" = $1 AND "status" = $2`,
+			args:  []interface{}{1, "new"},
+		},
+		{
+			name:  "null",
+			where: map[string]interface{}{"deleted": nil, "email": map[string]interface{}{"null": false}, "name": map[string]interface{}{"ne": nil}},
+			sql:   `"deleted" IS NULL AND "email" IS NOT NULL AND "name" IS NOT NULL`,
+		},
+		{
+			name:  "comparisons",
+			where: map[string]interface{}{"age": map[string]interface{}{"gte": 18, "lt": 65}, "name": map[string]interface{}{"ilike": "a%"}},
+			sql:   `"age" >= $1 AND "age" < $2 AND "name" ILIKE $3`,
+			args:  []interface{}{18, 65, "a%"},
+		},
+		{
+			name:  "lists",
+			where: map[string]interface{}{"id": map[string]interface{}{"in": []interface{}{1, 2}}, "role": map[string]interface{}{"notin": []string{"admin"}}, "score": map[string]interface{}{"between": []int{0, 9}}},
+			sql:   `"id" IN ($1,$2) AND "role" NOT IN ($3) AND "score" BETWEEN $4 AND $5`,
+			args:  []interface{}{1, 2, "admin", 0, 9},
+		},
+		{
+			name:  "empty in",
+			where: map[string]interface{}{"id": map[string]interface{}{"in": []interface{}{}}},
+			sql:   "FALSE",
+		},
+		{
+			name:  "json",
+			where: map[string]interface{}{"doc": map[string]interface{}{"hasKey": "k", "jsonPath": "$.a ? (@ > 1)"}, "tags": map[string]interface{}{"contains": []string{"x"}}},
+			sql:   `"doc" ? $1 AND "doc" @? $2::jsonpath AND "tags" @> $3`,
+			args:  []interface{}{"k", "$.a ? (@ > 1)", []string{"x"}},
+		},
+		{
+			name: "combinators",
+			where: map[string]interface{}{
+				"$or":  []interface{}{map[string]interface{}{"status": "new"}, map[string]interface{}{"age": map[string]interface{}{"gte": 18}}},
+				"$not": map[string]interface{}{"name": map[string]interface{}{"like": "test%"}},
+			},
+			sql:  `NOT ("name" LIKE $1) AND (("status" = $2) OR ("age" >= $3))`,
+			args: []interface{}{"test%", "new", 18},
+		},
+		{name: "quoted column", where: map[string]interface{}{`a"; DROP TABLE users; --`: 1}, sql: `"a""; DROP TABLE users; --" = $1`, args: []interface{}{1}},
+		{name: "unknown operator", where: map[string]interface{}{"a": map[string]interface{}{"regex": "x"}}, err: `unsupported where operator "regex"`},
+		{name: "unknown combinator", where: map[string]interface{}{"$xor": []interface{}{}}, err: `unsupported where combinator "$xor"`},
+		{name: "empty $or", where: map[string]interface{}{"$or": []interface{}{}}, err: "$or takes a non-empty list"},
+		{name: "empty $not", where: map[string]interface{}{"$not": map[string]interface{}{}}, err: "$not takes a non-empty object"},
+		{name: "no operator", where: map[string]interface{}{"a": map[string]interface{}{}}, err: "no operator given"},
+		{name: "between one bound", where: map[string]interface{}{"a": map[string]interface{}{"between": []int{1}}}, err: "takes a list of two bounds"},
+		{name: "in without list", where: map[string]interface{}{"a": map[string]interface{}{"in": 1}}, err: "takes a list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := BuildWhereClause("users", tt.where)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildWhereClause: %v", err)
+			}
+			if sql != tt.sql {
+				t.Errorf("got %s, want %s", sql, tt.sql)
+			}
+			if len(args) != len(tt.args) || (len(args) > 0 && !reflect.DeepEqual(args, tt.args)) {
+				t.Errorf("got args %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestBuildSelectQueryPage(t *testing.T) {
+	tests := []struct {
+		name string
+		page *dag.Page
+		sql  string
+		args []interface{}
+	}{
+		{name: "no page", sql: `SELECT "id" FROM "users" WHERE "status" = $1`, args: []interface{}{"new"}},
+		{
+			name: "first page",
+			page: &dag.Page{OrderBy: []dag.Order{{Column: "id"}}, Limit: 10, Offset: 20},
+			sql:  `SELECT "id" FROM "users" WHERE "status" = $1 ORDER BY "id" LIMIT 10 OFFSET 20`,
+			args: []interface{}{"new"},
+		},
+		{
+			name: "same direction",
+			page: &dag.Page{OrderBy: []dag.Order{{Column: "ts", Desc: true}, {Column: "id", Desc: true}}, Limit: 10, After: []interface{}{"2024", 5}},
+			sql:  `SELECT "id" FROM "users" WHERE "status" = $1 AND ("ts", "id") < ($2, $3) ORDER BY "ts" DESC, "id" DESC LIMIT 10`,
+			args: []interface{}{"new", "2024", 5},
+		},
+		{
+			name: "mixed directions",
+			page: &dag.Page{OrderBy: []dag.Order{{Column: "ts"}, {Column: "id", Desc: true}}, Limit: 10, After: []interface{}{"2024", 5}},
+			sql:  `SELECT "id" FROM "users" WHERE "status" = $1 AND (("ts" > $2) OR ("ts" = $3 AND "id" < $4)) ORDER BY "ts", "id" DESC LIMIT 10`,
+			args: []interface{}{"new", "2024", "2024", 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := BuildSelectQuery("users", []string{"id"}, map[string]interface{}{"status": "new"}, tt.page)
+			if err != nil {
+				t.Fatalf("BuildSelectQuery: %v", err)
+			}
+			if sql != tt.sql {
+				t.Errorf("got %s, want %s", sql, tt.sql)
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("got args %v, want %v", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name, in, column, table string
+	}{
+		{name: "plain", in: "users", column: `"users"`, table: `"users"`},
+		{name: "every column", in: "*", column: "*", table: `"*"`},
+		{name: "mixed case", in: "createdAt", column: `"createdAt"`, table: `"createdAt"`},
+		{name: "quote", in: `a"b`, column: `"a""b"`, table: `"a""b"`},
+		{name: "injection", in: `id; DROP TABLE users`, column: `"id; DROP TABLE users"`, table: `"id; DROP TABLE users"`},
+		{name: "schema", in: "public.users", column: `"public.users"`, table: `"public"."users"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quote(tt.in); got != tt.column {
+				t.Errorf("quote got %s, want %s", got, tt.column)
+			}
+			if got := quoteTable(tt.in); got != tt.table {
+				t.Errorf("quoteTable got %s, want %s", got, tt.table)
+			}
+		})
+	}
+}
+
+func TestBuildMutationQuery(t *testing.T) {
+	set := map[string]interface{}{"status": "done"}
+	tests := []struct {
+		name     string
+		update   bool
+		where    map[string]interface{}
+		mutation *dag.Mutation
+		sql      string
+		err      error
+	}{
+		{name: "update", update: true, where: map[string]interface{}{"id": 1}, sql: `UPDATE "users" SET "status" = $1 WHERE "id" = $2`},
+		{
+			name: "update returning", update: true, where: map[string]interface{}{"id": 1}, mutation: &dag.Mutation{Returning: []string{"id", "status"}},
+			sql: `UPDATE "users" SET "status" = $1 WHERE "id" = $2 RETURNING "id", "status"`,
+		},
+		{name: "delete", where: map[string]interface{}{"id": 1}, mutation: &dag.Mutation{Returning: []string{"*"}}, sql: `DELETE FROM "users" WHERE "id" = $1 RETURNING *`},
+		{name: "update every row", update: true, mutation: &dag.Mutation{AllowAll: true}, sql: `UPDATE "users" SET "status" = $1`},
+		{name: "delete every row", mutation: &dag.Mutation{AllowAll: true}, sql: `DELETE FROM "users"`},
+		{name: "update without where", update: true, err: dag.ErrEmptyWhere},
+		{name: "delete without where", mutation: &dag.Mutation{}, err: dag.ErrEmptyWhere},
+		{name: "update with an empty notin", update: true, where: map[string]interface{}{"id": map[string]interface{}{"notin": []interface{}{}}}, err: dag.ErrUnboundedWhere},
+		{name: "delete with a negated empty in", where: map[string]interface{}{"$not": map[string]interface{}{"id": map[string]interface{}{"in": []interface{}{}}}}, err: dag.ErrUnboundedWhere},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sql string
+			var err error
+			if tt.update {
+				sql, _, err = BuildUpdateQuery("users", set, tt.where, tt.mutation)
+			} else {
+				sql, _, err = BuildDeleteQuery("users", tt.where, tt.mutation)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if sql != tt.sql {
+				t.Errorf("got %s, want %s", sql, tt.sql)
+			}
+		})
+	}
+}
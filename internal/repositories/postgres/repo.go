@@ -6,12 +6,29 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
 )
 
 // Postgres handles database operations for the DAG executor
 type Postgres struct {
-	pool *pgxpool.Pool
+	pool   *pgxpool.Pool
+	conn   conn // the pool, or the transaction of a PostgresTx
+	schema *schema
+}
+
+// conn is what pgxpool.Pool and pgx.Tx have in common
+type conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+}
+
+// PostgresTx runs the operations of a Postgres in a single transaction
+type PostgresTx struct {
+	Postgres
+	tx pgx.Tx
 }
 
 // NewPostgres creates a new PostgreSQL repository
@@ -37,7 +54,10 @@ func NewPostgres(connStr string) (*Postgres, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	return &Postgres{pool: pool}, nil
+	r := &Postgres{pool: pool, conn: pool}
+	// the catalogue is looked up through the pool, never inside a transaction
+	r.schema = &schema{loadTables: r.GetTableNames, loadColumns: r.GetColumns}
+	return r, nil
 }
 
 // Close closes the database connection pool
@@ -46,10 +66,10 @@ func (r *Postgres) Close() error {
 	return nil
 }
 
-// Query executes a query and returns the results
-func (r *Postgres) query(query string, args ...interface{}) ([]interface{}, error) {
+// Query executes a query on c and returns the results
+func query(ctx context.Context, c conn, query string, args ...interface{}) ([]interface{}, error) {
 	// Execute query
-	rows, err := r.pool.Query(context.Background(), query, args...)
+	rows, err := c.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -89,62 +109,157 @@ func (r *Postgres) query(query string, args ...interface{}) ([]interface{}, erro
 	return result, nil
 }
 
-// Insert executes an insert query and returns the number of affected rows
-func (r *Postgres) mutate(query string, args ...interface{}) (int64, error) {
-	result, err := r.pool.Exec(context.Background(), query, args...)
+// mutate executes a data-modifying query in a transaction and returns the
+// number of affected rows, together with the rows its RETURNING clause
+// returns when the mutation asks for columns. The transaction is rolled back
+// instead of committed when ctx is cancelled while the query runs or when
+// more rows are affected than the mutation allows.
+func (r *Postgres) mutate(ctx context.Context, mutation *dag.Mutation, sql string, args ...interface{}) (interface{}, error) {
+	var result interface{}
+	err := r.executeInTransaction(ctx, func(tx *pgx.Tx) error {
+		if mutation != nil && len(mutation.Returning) > 0 {
+			rows, err := query(ctx, *tx, sql, args...)
+			if err != nil {
+				return fmt.Errorf("failed to execute mutation: %w", err)
+			}
+			result = dag.MutationResult(int64(len(rows)), rows)
+			return mutation.CheckAffected(int64(len(rows)))
+		}
+		tag, err := (*tx).Exec(ctx, sql, args...)
+		if err != nil {
+			return fmt.Errorf("failed to execute mutation: %w", err)
+		}
+		result = tag.RowsAffected()
+		return mutation.CheckAffected(tag.RowsAffected())
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute insert: %w", err)
+		return nil, err
 	}
-
-	return result.RowsAffected(), nil
+	return result, nil
 }
 
-// ExecuteInTransaction executes the given function within a transaction
-func (r *Postgres) executeInTransaction(fn func(*pgx.Tx) error) error {
-	tx, err := r.pool.Begin(context.Background())
+// ExecuteInTransaction executes the given function within a transaction, a
+// savepoint when r is already bound to one
+func (r *Postgres) executeInTransaction(ctx context.Context, fn func(*pgx.Tx) error) error {
+	tx, err := r.conn.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	if err := fn(&tx); err != nil {
+	err = fn(&tx)
+	if err == nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	if err != nil {
+		// roll back on a fresh context so a cancelled ctx does not leave the tx open
 		if rbErr := tx.Rollback(context.Background()); rbErr != nil {
 			return fmt.Errorf("failed to rollback transaction: %v (original error: %v)", rbErr, err)
 		}
 		return err
 	}
 
-	if err := tx.Commit(context.Background()); err != nil {
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Begin starts a transaction the operations of the returned PostgresTx run
+// in. Beginning on a PostgresTx creates a savepoint.
+func (r *Postgres) Begin(ctx context.Context) (dag.Tx, error) {
+	tx, err := r.conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &PostgresTx{Postgres: Postgres{pool: r.pool, conn: tx, schema: r.schema}, tx: tx}, nil
+}
+
+// Commit commits the transaction
+func (t *PostgresTx) Commit(ctx context.Context) error {
+	if err := t.tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return nil
+}
 
+// Rollback rolls the transaction back
+func (t *PostgresTx) Rollback(ctx context.Context) error {
+	if err := t.tx.Rollback(ctx); err != nil {
+		return fmt.Errorf("failed to rollback transaction: %w", err)
+	}
 	return nil
 }
 
-func (r *Postgres) Create(table string, mapping map[string]interface{}) (interface{}, error) {
-	query, args, err := BuildInsertQuery(table, mapping)
+// Create inserts a row, once the table and columns are known to exist
+func (r *Postgres) Create(ctx context.Context, table string, mapping map[string]interface{}, returning []string) (interface{}, error) {
+	columns := make([]string, 0, len(mapping))
+	for column := range mapping {
+		columns = append(columns, column)
+	}
+	if err := r.CheckIdentifiers(ctx, table, append(columns, returning...)); err != nil {
+		return nil, err
+	}
+	sql, args, err := BuildInsertQuery(table, mapping, returning)
+	if err != nil {
+		return nil, err
+	}
+	return r.mutate(ctx, &dag.Mutation{Returning: returning}, sql, args...)
+}
+
+// Update updates the matching rows, once the table and columns are known to exist
+func (r *Postgres) Update(ctx context.Context, table string, mapping map[string]interface{}, where map[string]interface{}, mutation *dag.Mutation) (interface{}, error) {
+	columns := make([]string, 0, len(mapping))
+	for column := range mapping {
+		columns = append(columns, column)
+	}
+	if err := r.CheckIdentifiers(ctx, table, whereIdentifiers(where, append(columns, returning(mutation)...)...)); err != nil {
+		return nil, err
+	}
+	sql, args, err := BuildUpdateQuery(table, mapping, where, mutation)
 	if err != nil {
 		return nil, err
 	}
-	return r.mutate(query, args...)
+	return r.mutate(ctx, mutation, sql, args...)
 }
 
-func (r *Postgres) Update(table string, mapping map[string]interface{}, where map[string]interface{}) (interface{}, error) {
-	query, args := BuildUpdateQuery(table, mapping, where)
-	return r.mutate(query, args...)
+// Retrieve returns the matching rows, once the table and columns are known to exist
+func (r *Postgres) Retrieve(ctx context.Context, table string, columns []string, where map[string]interface{}, page *dag.Page) ([]interface{}, error) {
+	identifiers := whereIdentifiers(where, columns...)
+	if page != nil {
+		for _, order := range page.OrderBy {
+			identifiers = append(identifiers, order.Column)
+		}
+	}
+	if err := r.CheckIdentifiers(ctx, table, identifiers); err != nil {
+		return nil, err
+	}
+	return r.retrieve(ctx, table, columns, where, page)
 }
 
-func (r *Postgres) Retrieve(table string, columns []string, where map[string]interface{}) ([]interface{}, error) {
-	query, args := BuildSelectQuery(table, columns, where)
-	return r.query(query, args...)
+// retrieve returns the matching rows without checking the identifiers
+func (r *Postgres) retrieve(ctx context.Context, table string, columns []string, where map[string]interface{}, page *dag.Page) ([]interface{}, error) {
+	sql, args, err := BuildSelectQuery(table, columns, where, page)
+	if err != nil {
+		return nil, err
+	}
+	return query(ctx, r.conn, sql, args...)
 }
 
-func (r *Postgres) Delete(table string, where map[string]interface{}) (interface{}, error) {
-	query, args := BuildDeleteQuery(table, where)
-	return r.mutate(query, args...)
+// Delete deletes the matching rows, once the table and columns are known to exist
+func (r *Postgres) Delete(ctx context.Context, table string, where map[string]interface{}, mutation *dag.Mutation) (interface{}, error) {
+	if err := r.CheckIdentifiers(ctx, table, whereIdentifiers(where, returning(mutation)...)); err != nil {
+		return nil, err
+	}
+	sql, args, err := BuildDeleteQuery(table, where, mutation)
+	if err != nil {
+		return nil, err
+	}
+	return r.mutate(ctx, mutation, sql, args...)
 }
 
-func (r *Postgres) GetTableNames() ([]string, error) {
-	rows, err := r.Retrieve("information_schema.tables", []string{"table_name"}, map[string]interface{}{"table_schema": "public"})
+func (r *Postgres) GetTableNames(ctx context.Context) ([]string, error) {
+	rows, err := r.retrieve(ctx, "information_schema.tables", []string{"table_name"}, map[string]interface{}{"table_schema": "public"}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +270,8 @@ func (r *Postgres) GetTableNames() ([]string, error) {
 	return tableNames, nil
 }
 
-func (r *Postgres) GetColumns(tableName string) (map[string]string, error) {
-	rows, err := r.Retrieve("information_schema.columns", []string{"column_name", "udt_name"}, map[string]interface{}{"table_name": tableName, "table_schema": "public"})
+func (r *Postgres) GetColumns(ctx context.Context, tableName string) (map[string]string, error) {
+	rows, err := r.retrieve(ctx, "information_schema.columns", []string{"column_name", "udt_name"}, map[string]interface{}{"table_name": tableName, "table_schema": "public"}, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -168,3 +283,23 @@ func (r *Postgres) GetColumns(tableName string) (map[string]string, error) {
 	}
 	return schema, nil
 }
+
+// PlanRetrieve returns the statement Retrieve would run
+func (r *Postgres) PlanRetrieve(table string, columns []string, where map[string]interface{}, page *dag.Page) (string, []interface{}, error) {
+	return BuildSelectQuery(table, columns, where, page)
+}
+
+// PlanCreate returns the statement Create would run
+func (r *Postgres) PlanCreate(table string, mapping map[string]interface{}, returning []string) (string, []interface{}, error) {
+	return BuildInsertQuery(table, mapping, returning)
+}
+
+// PlanUpdate returns the statement Update would run
+func (r *Postgres) PlanUpdate(table string, mapping map[string]interface{}, where map[string]interface{}, mutation *dag.Mutation) (string, []interface{}, error) {
+	return BuildUpdateQuery(table, mapping, where, mutation)
+}
+
+// PlanDelete returns the statement Delete would run
+func (r *Postgres) PlanDelete(table string, where map[string]interface{}, mutation *dag.Mutation) (string, []interface{}, error) {
+	return BuildDeleteQuery(table, where, mutation)
+}
@@ -0,0 +1,186 @@
+package respositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// createRunsTable keeps the run record as a JSON document with the columns
+// runs are filtered by next to it
+const createRunsTable = `CREATE TABLE IF NOT EXISTS dag_runs (
+	id         text PRIMARY KEY,
+	dag_id     text NOT NULL DEFAULT '',
+	status     text NOT NULL,
+	created_at timestamptz NOT NULL,
+	data       jsonb NOT NULL
+)`
+
+// RunStore keeps run records in PostgreSQL
+type RunStore struct {
+	pool *pgxpool.Pool
+}
+
+// RunStore returns a run store sharing the repository's connection pool,
+// creating the dag_runs table when it does not exist yet
+func (r *Postgres) RunStore(ctx context.Context) (*RunStore, error) {
+	if _, err := r.pool.Exec(ctx, createRunsTable); err != nil {
+		return nil, fmt.Errorf("failed to create runs table: %w", err)
+	}
+	return &RunStore{pool: r.pool}, nil
+}
+
+// CreateRun stores a new run record
+func (s *RunStore) CreateRun(ctx context.Context, run *dag.Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf("failed to marshal run: %w", err)
+	}
+	_, err = s.pool.Exec(ctx,
+		`INSERT INTO dag_runs (id, dag_id, status, created_at, data) VALUES ($1, $2, $3, $4, $5::jsonb)`,
+		run.ID, run.DAGID, string(run.Status), run.CreatedAt, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to save run: %w", err)
+	}
+	return nil
+}
+
+// UpdateRun replaces the stored state of a run, keeping its checkpointed results
+func (s *RunStore) UpdateRun(ctx context.Context, run *dag.Run) error {
+	_, err := s.updateRun(ctx, run, "")
+	return err
+}
+
+// ClaimRun updates a run like UpdateRun while it still has the given status
+// and number of resumes, and reports whether it did
+func (s *RunStore) ClaimRun(ctx context.Context, run *dag.Run, status dag.RunStatus, resumes int) (bool, error) {
+	claimed, err := s.updateRun(ctx, run, ` AND status = $5 AND COALESCE((data->>'resumes')::int, 0) = $6`, string(status), resumes)
+	if err != nil {
+		return false, err
+	}
+	return claimed > 0, nil
+}
+
+// updateRun replaces the stored state of a run where condition holds and
+// returns the number of runs it updated. The stored results are kept as they
+// are, since only Checkpoint writes them. The condition takes its arguments
+// from $5 on.
+func (s *RunStore) updateRun(ctx context.Context, run *dag.Run, condition string, args ...interface{}) (int64, error) {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal run: %w", err)
+	}
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE dag_runs SET dag_id = $2, status = $3,
+			data = $4::jsonb || jsonb_build_object('results', COALESCE(data->'results', '{}'::jsonb))
+		WHERE id = $1`+condition,
+		append([]interface{}{run.ID, run.DAGID, string(run.Status), string(data)}, args...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update run: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Checkpoint records the trace and result of a single step of a run
+func (s *RunStore) Checkpoint(ctx context.Context, runID string, step dag.StepTrace, result interface{}) error {
+	stepData, resultData, err := checkpointData(step, result)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.Exec(ctx,
+		`UPDATE dag_runs SET data = jsonb_set(
+			jsonb_set(data, '{results}', COALESCE(data->'results', '{}'::jsonb) || jsonb_build_object($2::text, $3::jsonb)),
+			ARRAY['trace', 'steps', $2::text], $4::jsonb)
+		WHERE id = $1`,
+		runID, step.StepID, string(resultData), string(stepData))
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// checkpointData encodes the trace and result of a step as JSON
+func checkpointData(step dag.StepTrace, result interface{}) (stepData, resultData []byte, err error) {
+	if stepData, err = json.Marshal(step); err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal step trace: %w", err)
+	}
+	if resultData, err = json.Marshal(result); err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal step result: %w", err)
+	}
+	return stepData, resultData, nil
+}
+
+// GetRun retrieves a run record by ID
+func (s *RunStore) GetRun(ctx context.Context, id string) (*dag.Run, error) {
+	var data []byte
+	err := s.pool.QueryRow(ctx, `SELECT data FROM dag_runs WHERE id = $1`, id).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", dag.ErrRunNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve run: %w", err)
+	}
+
+	var run dag.Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal run: %w", err)
+	}
+	return &run, nil
+}
+
+// ListRuns retrieves the runs matching filter, newest first
+func (s *RunStore) ListRuns(ctx context.Context, filter dag.RunFilter) ([]dag.Run, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.DAGID != "" {
+		addCondition("dag_id = $%d", filter.DAGID)
+	}
+	if filter.Status != "" {
+		addCondition("status = $%d", string(filter.Status))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		addCondition("created_at >= $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		addCondition("created_at <= $%d", filter.CreatedBefore)
+	}
+
+	query := `SELECT data FROM dag_runs`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]dag.Run, 0)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan run: %w", err)
+		}
+		var run dag.Run
+		if err := json.Unmarshal(data, &run); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during row iteration: %w", err)
+	}
+	return runs, nil
+}
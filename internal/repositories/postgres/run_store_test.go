@@ -0,0 +1,108 @@
+package respositories
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// testRunStore connects to the database at TEST_DATABASE_URL and creates a
+// run that is deleted again when the test ends. Tests using it are skipped
+// without a database.
+func testRunStore(t *testing.T) (*RunStore, *dag.Run) {
+	t.Helper()
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	pg, err := NewPostgres(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pg.Close() })
+	ctx := context.Background()
+	store, err := pg.RunStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := &dag.Run{ID: uuid.NewString(), Status: dag.RunRunning, Trace: &dag.Trace{Steps: map[string]*dag.StepTrace{}}}
+	if err := store.CreateRun(ctx, run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := pg.pool.Exec(context.Background(), `DELETE FROM dag_runs WHERE id = $1`, run.ID); err != nil {
+			t.Errorf("failed to delete run: %v", err)
+		}
+	})
+	return store, run
+}
+
+func TestCheckpoint(t *testing.T) {
+	store, run := testRunStore(t)
+	ctx := context.Background()
+	step := dag.StepTrace{StepID: "post", Status: dag.StepSucceeded}
+	if err := store.Checkpoint(ctx, run.ID, step, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	stored, err := store.GetRun(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	result, _ := stored.Results["post"].(map[string]interface{})
+	if result["id"] != float64(1) {
+		t.Errorf("got results %v, want the result of post", stored.Results)
+	}
+	if step, ok := stored.Trace.Step("post"); !ok || step.Status != dag.StepSucceeded {
+		t.Errorf("got trace %v, want post succeeded", stored.Trace.Steps)
+	}
+}
+
+func TestResumeKeepsCheckpointedResults(t *testing.T) {
+	store, run := testRunStore(t)
+	ctx := context.Background()
+	// execute checkpoints a step of a copy of run and records it with status
+	execute := func(run *dag.Run, stepID string, status dag.RunStatus) {
+		t.Helper()
+		launched := *run
+		step := dag.StepTrace{StepID: stepID, Status: dag.StepSucceeded}
+		if err := store.Checkpoint(ctx, run.ID, step, stepID+" result"); err != nil {
+			t.Fatalf("Checkpoint: %v", err)
+		}
+		launched.Status = status
+		if err := store.UpdateRun(ctx, &launched); err != nil {
+			t.Fatalf("UpdateRun: %v", err)
+		}
+	}
+
+	execute(run, "a", dag.RunFailed)
+	resumed, err := store.GetRun(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	resumed.Status, resumed.Resumes = dag.RunRunning, 1
+	if claimed, err := store.ClaimRun(ctx, resumed, dag.RunFailed, 0); err != nil || !claimed {
+		t.Fatalf("ClaimRun: %v, %v", claimed, err)
+	}
+	if claimed, err := store.ClaimRun(ctx, resumed, dag.RunFailed, 0); err != nil || claimed {
+		t.Fatalf("second ClaimRun: %v, %v", claimed, err)
+	}
+	execute(resumed, "b", dag.RunSucceeded)
+
+	stored, err := store.GetRun(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if stored.Status != dag.RunSucceeded {
+		t.Fatalf("run %s, want succeeded", stored.Status)
+	}
+	for _, id := range []string{"a", "b"} {
+		if stored.Results[id] != id+" result" {
+			t.Errorf("result of %s = %v, want %q", id, stored.Results[id], id+" result")
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package respositories
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// schemaTTL is how long the tables and columns identifiers are checked
+// against are cached before they are looked up again
+const schemaTTL = time.Minute
+
+// schema caches the tables of the public schema and, once asked for, their
+// columns. It is shared by a Postgres and its transactions.
+type schema struct {
+	loadTables  func(ctx context.Context) ([]string, error)
+	loadColumns func(ctx context.Context, table string) (map[string]string, error)
+
+	mu       sync.Mutex
+	tables   map[string]map[string]string // table -> column -> type, nil until looked up
+	loadedAt time.Time
+}
+
+// CheckIdentifiers returns dag.ErrUnknownTable or dag.ErrUnknownColumn unless
+// the public schema has the table and every one of the columns. "*" stands
+// for every column.
+func (r *Postgres) CheckIdentifiers(ctx context.Context, table string, columns []string) error {
+	known, err := r.schema.columns(ctx, table)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if _, ok := known[column]; !ok && column != "*" {
+			return fmt.Errorf("%w: %s.%s", dag.ErrUnknownColumn, table, column)
+		}
+	}
+	return nil
+}
+
+// columns returns the columns of a table, looking the catalogue up again once
+// it is older than schemaTTL. The lock is only held to read and swap the
+// cache, so steps do not wait on each other's lookups.
+func (s *schema) columns(ctx context.Context, table string) (map[string]string, error) {
+	s.mu.Lock()
+	fresh := s.tables != nil && time.Since(s.loadedAt) <= schemaTTL
+	known, ok := s.tables[table]
+	s.mu.Unlock()
+
+	if !fresh {
+		names, err := s.loadTables(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up tables: %w", err)
+		}
+		tables := make(map[string]map[string]string, len(names))
+		for _, name := range names {
+			tables[name] = nil
+		}
+		s.mu.Lock()
+		s.tables, s.loadedAt = tables, time.Now()
+		known, ok = tables[table]
+		s.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", dag.ErrUnknownTable, table)
+	}
+	if known != nil {
+		return known, nil
+	}
+
+	known, err := s.loadColumns(ctx, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the columns of %s: %w", table, err)
+	}
+	s.mu.Lock()
+	if _, ok := s.tables[table]; ok {
+		s.tables[table] = known
+	}
+	s.mu.Unlock()
+	return known, nil
+}
+
+// returning returns the columns a mutation returns
+func returning(mutation *dag.Mutation) []string {
+	if mutation == nil {
+		return nil
+	}
+	return mutation.Returning
+}
+
+// whereIdentifiers returns the columns of where, followed by the given ones
+func whereIdentifiers(where map[string]interface{}, columns ...string) []string {
+	return append(dag.WhereColumns(where), columns...)
+}
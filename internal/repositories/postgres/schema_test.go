@@ -0,0 +1,127 @@
+package respositories
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+// catalogue is a fake information schema counting its lookups
+type catalogue struct {
+	tables       map[string]map[string]string
+	tableLoads   int64
+	columnLoads  int64
+	columnsBlock map[string]chan struct{} // closed to let a column lookup finish
+	columnsStart chan string
+}
+
+func (c *catalogue) schema() *schema {
+	return &schema{
+		loadTables: func(ctx context.Context) ([]string, error) {
+			atomic.AddInt64(&c.tableLoads, 1)
+			names := make([]string, 0, len(c.tables))
+			for name := range c.tables {
+				names = append(names, name)
+			}
+			return names, nil
+		},
+		loadColumns: func(ctx context.Context, table string) (map[string]string, error) {
+			atomic.AddInt64(&c.columnLoads, 1)
+			if block, ok := c.columnsBlock[table]; ok {
+				c.columnsStart <- table
+				<-block
+			}
+			return c.tables[table], nil
+		},
+	}
+}
+
+func newCatalogue() *catalogue {
+	return &catalogue{tables: map[string]map[string]string{
+		"users":  {"id": "int4", "email": "text"},
+		"orders": {"id": "int4", "user_id": "int4"},
+	}}
+}
+
+func TestCheckIdentifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		table   string
+		columns []string
+		err     error
+	}{
+		{name: "table", table: "users"},
+		{name: "columns", table: "users", columns: []string{"id", "email"}},
+		{name: "every column", table: "users", columns: []string{"*"}},
+		{name: "unknown table", table: "accounts", err: dag.ErrUnknownTable},
+		{name: "unknown column", table: "users", columns: []string{"id", "password"}, err: dag.ErrUnknownColumn},
+		{name: "injected column", table: "users", columns: []string{`id"; DROP TABLE users; --`}, err: dag.ErrUnknownColumn},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Postgres{schema: newCatalogue().schema()}
+			err := r.CheckIdentifiers(context.Background(), tt.table, tt.columns)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckIdentifiersCache(t *testing.T) {
+	c := newCatalogue()
+	r := &Postgres{schema: c.schema()}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := r.CheckIdentifiers(ctx, "users", []string{"id"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.tableLoads != 1 || c.columnLoads != 1 {
+		t.Fatalf("got %d table and %d column lookups, want 1 and 1", c.tableLoads, c.columnLoads)
+	}
+
+	r.schema.loadedAt = time.Now().Add(-2 * schemaTTL)
+	if err := r.CheckIdentifiers(ctx, "users", []string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.tableLoads != 2 || c.columnLoads != 2 {
+		t.Fatalf("got %d table and %d column lookups after expiry, want 2 and 2", c.tableLoads, c.columnLoads)
+	}
+}
+
+func TestCheckIdentifiersLookupDoesNotBlock(t *testing.T) {
+	c := newCatalogue()
+	release := make(chan struct{})
+	c.columnsBlock = map[string]chan struct{}{"orders": release}
+	c.columnsStart = make(chan string, 1)
+	r := &Postgres{schema: c.schema()}
+	ctx := context.Background()
+	if err := r.CheckIdentifiers(ctx, "users", []string{"id"}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := make(chan error, 1)
+	go func() { slow <- r.CheckIdentifiers(ctx, "orders", []string{"user_id"}) }()
+	<-c.columnsStart
+
+	fast := make(chan error, 1)
+	go func() { fast <- r.CheckIdentifiers(ctx, "users", []string{"email"}) }()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached lookup waited for another table's column lookup")
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+}
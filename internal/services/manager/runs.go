@@ -0,0 +1,202 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const runsCollection = "runs"
+
+// documentStore is the part of the MongoDB repository the run store uses
+type documentStore interface {
+	Create(ctx context.Context, collection string, data map[string]interface{}, returning []string) (interface{}, error)
+	Retrieve(ctx context.Context, collection string, fields []string, filter map[string]interface{}, page *dag.Page) ([]interface{}, error)
+	Update(ctx context.Context, collection string, update map[string]interface{}, filter map[string]interface{}, mutation *dag.Mutation) (interface{}, error)
+}
+
+// RunStore keeps run records in MongoDB next to the DAG definitions
+type RunStore struct {
+	db documentStore
+}
+
+// RunStore returns a run store sharing the manager's MongoDB connection
+func (m *ManagerService) RunStore() *RunStore {
+	return &RunStore{db: m.db}
+}
+
+// CreateRun stores a new run record
+func (s *RunStore) CreateRun(ctx context.Context, run *dag.Run) error {
+	data, err := runDocument(run)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Create(ctx, runsCollection, data, nil); err != nil {
+		return fmt.Errorf("failed to save run: %w", err)
+	}
+	return nil
+}
+
+// UpdateRun replaces the stored state of a run, keeping its checkpointed results
+func (s *RunStore) UpdateRun(ctx context.Context, run *dag.Run) error {
+	data, err := runUpdate(run)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Update(ctx, runsCollection, data, map[string]interface{}{"id": run.ID}, nil); err != nil {
+		return fmt.Errorf("failed to update run: %w", err)
+	}
+	return nil
+}
+
+// ClaimRun updates a run like UpdateRun while it still has the given status
+// and number of resumes, and reports whether it did
+func (s *RunStore) ClaimRun(ctx context.Context, run *dag.Run, status dag.RunStatus, resumes int) (bool, error) {
+	data, err := runUpdate(run)
+	if err != nil {
+		return false, err
+	}
+	// runs that were never resumed have no resumes field
+	where := map[string]interface{}{"id": run.ID, "status": string(status), "resumes": map[string]interface{}{"null": true}}
+	if resumes > 0 {
+		where["resumes"] = resumes
+	}
+	count, err := s.db.Update(ctx, runsCollection, data, where, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim run: %w", err)
+	}
+	claimed, _ := count.(int64)
+	return claimed > 0, nil
+}
+
+// Checkpoint records the trace and result of a single step of a run
+func (s *RunStore) Checkpoint(ctx context.Context, runID string, step dag.StepTrace, result interface{}) error {
+	data, err := checkpointDocument(step, result)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Update(ctx, runsCollection, data, map[string]interface{}{"id": runID}, nil); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// GetRun retrieves a run record by ID
+func (s *RunStore) GetRun(ctx context.Context, id string) (*dag.Run, error) {
+	results, err := s.db.Retrieve(ctx, runsCollection, []string{}, map[string]interface{}{"id": id}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve run: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: %s", dag.ErrRunNotFound, id)
+	}
+	return decodeRun(results[0])
+}
+
+// ListRuns retrieves the runs matching filter, newest first
+func (s *RunStore) ListRuns(ctx context.Context, filter dag.RunFilter) ([]dag.Run, error) {
+	query := map[string]interface{}{}
+	if filter.DAGID != "" {
+		query["dagId"] = filter.DAGID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	createdAt := map[string]interface{}{}
+	if !filter.CreatedAfter.IsZero() {
+		createdAt["gte"] = filter.CreatedAfter
+	}
+	if !filter.CreatedBefore.IsZero() {
+		createdAt["lte"] = filter.CreatedBefore
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	results, err := s.db.Retrieve(ctx, runsCollection, []string{}, query, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	runs := make([]dag.Run, len(results))
+	for i, result := range results {
+		run, err := decodeRun(result)
+		if err != nil {
+			return nil, err
+		}
+		runs[i] = *run
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	return runs, nil
+}
+
+// runDocument converts a run into the document stored in MongoDB
+func runDocument(run *dag.Run) (map[string]interface{}, error) {
+	bsonBytes, err := bson.Marshal(run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal run: %w", err)
+	}
+	var data bson.M
+	if err := bson.Unmarshal(bsonBytes, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal run document: %w", err)
+	}
+	return data, nil
+}
+
+// runUpdate returns the fields UpdateRun sets. They leave out the results,
+// which only Checkpoint writes: the run being updated was copied when it was
+// launched and lacks the results checkpointed since.
+func runUpdate(run *dag.Run) (map[string]interface{}, error) {
+	data, err := runDocument(run)
+	if err != nil {
+		return nil, err
+	}
+	delete(data, "results")
+	return data, nil
+}
+
+// checkpointDocument converts the trace and result of a step into the fields
+// of the run document Checkpoint sets
+func checkpointDocument(step dag.StepTrace, result interface{}) (map[string]interface{}, error) {
+	bsonBytes, err := bson.Marshal(bson.M{
+		"results." + step.StepID:     result,
+		"trace.steps." + step.StepID: step,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	var data bson.M
+	if err := bson.Unmarshal(bsonBytes, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint document: %w", err)
+	}
+	return data, nil
+}
+
+// decodeRun converts a stored document back into a run. It goes through a map
+// and JSON like GetDAG so nested output documents come back as plain maps.
+func decodeRun(result interface{}) (*dag.Run, error) {
+	var rawData map[string]interface{}
+	bsonBytes, err := bson.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal BSON: %w", err)
+	}
+	if err := bson.Unmarshal(bsonBytes, &rawData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal BSON to map: %w", err)
+	}
+
+	jsonBytes, err := json.Marshal(rawData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal to JSON: %w", err)
+	}
+	var run dag.Run
+	if err := json.Unmarshal(jsonBytes, &run); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal to run: %w", err)
+	}
+	return &run, nil
+}
@@ -0,0 +1,225 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/lynnphayu/dag-runner/pkg/dag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryStore keeps the documents of a single collection in memory. Filters
+// only compare fields for equality or null, and updates set fields like $set,
+// following dotted paths into nested documents.
+type memoryStore struct {
+	documents []bson.M
+}
+
+func (m *memoryStore) Create(ctx context.Context, collection string, data map[string]interface{}, returning []string) (interface{}, error) {
+	document := bson.M{}
+	for key, value := range data {
+		document[key] = clone(value)
+	}
+	m.documents = append(m.documents, document)
+	return int64(1), nil
+}
+
+func (m *memoryStore) Retrieve(ctx context.Context, collection string, fields []string, filter map[string]interface{}, page *dag.Page) ([]interface{}, error) {
+	results := make([]interface{}, 0)
+	for _, document := range m.documents {
+		if matches(document, filter) {
+			results = append(results, clone(document))
+		}
+	}
+	return results, nil
+}
+
+func (m *memoryStore) Update(ctx context.Context, collection string, update map[string]interface{}, filter map[string]interface{}, mutation *dag.Mutation) (interface{}, error) {
+	var matched int64
+	for _, document := range m.documents {
+		if !matches(document, filter) {
+			continue
+		}
+		matched++
+		for path, value := range update {
+			keys := strings.Split(path, ".")
+			parent := document
+			for _, key := range keys[:len(keys)-1] {
+				nested, ok := parent[key].(bson.M)
+				if !ok {
+					nested = bson.M{}
+					parent[key] = nested
+				}
+				parent = nested
+			}
+			parent[keys[len(keys)-1]] = clone(value)
+		}
+	}
+	return matched, nil
+}
+
+// matches reports whether a document passes a filter of equalities and
+// {"null": true} conditions
+func matches(document bson.M, filter map[string]interface{}) bool {
+	for field, value := range filter {
+		if condition, ok := value.(map[string]interface{}); ok && condition["null"] == true {
+			if document[field] != nil {
+				return false
+			}
+			continue
+		}
+		if !bytes.Equal(encode(document[field]), encode(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// encode returns the BSON encoding of a value, so values compare the way
+// MongoDB compares them
+func encode(value interface{}) []byte {
+	data, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// clone returns a copy of a value as MongoDB would store it
+func clone(value interface{}) interface{} {
+	var decoded bson.M
+	if err := bson.Unmarshal(encode(value), &decoded); err != nil {
+		panic(err)
+	}
+	return decoded["v"]
+}
+
+func TestCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	db := &memoryStore{}
+	store := &RunStore{db: db}
+	run := &dag.Run{ID: "run-1", Status: dag.RunRunning, Trace: &dag.Trace{Steps: map[string]*dag.StepTrace{}}}
+	if err := store.CreateRun(ctx, run); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	step := dag.StepTrace{StepID: "post", Status: dag.StepSucceeded}
+	result := &dag.ParsedResponse{Data: map[string]interface{}{"id": 1}, StatusCode: 200}
+	if err := store.Checkpoint(ctx, run.ID, step, result); err != nil {
+		t.Fatalf("Checkpoint: %v", err)
+	}
+
+	// the step is set inside the results and the trace, not as dotted fields
+	document := db.documents[0]
+	results, _ := document["results"].(bson.M)
+	if response, _ := results["post"].(bson.M); response["statuscode"] != int32(200) {
+		t.Errorf("got results %v, want the response of post", document["results"])
+	}
+	trace, _ := document["trace"].(bson.M)
+	if steps, _ := trace["steps"].(bson.M); steps["post"] == nil {
+		t.Errorf("got trace %v, want the step post", document["trace"])
+	}
+
+	stored, err := store.GetRun(ctx, run.ID)
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if results := stored.SucceededResults(); results["post"] == nil {
+		t.Errorf("got results %v, want the result of post", results)
+	}
+}
+
+func TestClaimRun(t *testing.T) {
+	ctx := context.Background()
+	store := &RunStore{db: &memoryStore{}}
+	if err := store.CreateRun(ctx, &dag.Run{ID: "run-1", Status: dag.RunFailed}); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	get := func() *dag.Run {
+		t.Helper()
+		run, err := store.GetRun(ctx, "run-1")
+		if err != nil {
+			t.Fatalf("GetRun: %v", err)
+		}
+		return run
+	}
+	claim := func(run *dag.Run) bool {
+		t.Helper()
+		status, resumes := run.Status, run.Resumes
+		run.Status, run.Resumes = dag.RunRunning, resumes+1
+		claimed, err := store.ClaimRun(ctx, run, status, resumes)
+		if err != nil {
+			t.Fatalf("ClaimRun: %v", err)
+		}
+		return claimed
+	}
+
+	// two processes resume the failed run at once
+	first, second := get(), get()
+	if !claim(first) {
+		t.Fatal("first claim of the failed run failed")
+	}
+	if claim(second) {
+		t.Fatal("second claim of the failed run succeeded")
+	}
+
+	// the process executing it dies, leaving the run running
+	orphaned, again := get(), get()
+	if orphaned.Status != dag.RunRunning {
+		t.Fatalf("claimed run %s, want running", orphaned.Status)
+	}
+	if !claim(orphaned) {
+		t.Fatal("claim of the orphaned run failed")
+	}
+	if claim(again) {
+		t.Fatal("second claim of the orphaned run succeeded")
+	}
+}
+
+func TestResumeKeepsCheckpointedResults(t *testing.T) {
+	ctx := context.Background()
+	store := &RunStore{db: &memoryStore{}}
+	if err := store.CreateRun(ctx, &dag.Run{ID: "run-1", Status: dag.RunRunning}); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	get := func() *dag.Run {
+		t.Helper()
+		run, err := store.GetRun(ctx, "run-1")
+		if err != nil {
+			t.Fatalf("GetRun: %v", err)
+		}
+		return run
+	}
+	// execute checkpoints a step of a copy of run and records it with status
+	execute := func(run *dag.Run, stepID string, status dag.RunStatus) {
+		t.Helper()
+		launched := *run
+		step := dag.StepTrace{StepID: stepID, Status: dag.StepSucceeded}
+		if err := store.Checkpoint(ctx, run.ID, step, strings.ToUpper(stepID)); err != nil {
+			t.Fatalf("Checkpoint: %v", err)
+		}
+		launched.Status = status
+		if err := store.UpdateRun(ctx, &launched); err != nil {
+			t.Fatalf("UpdateRun: %v", err)
+		}
+	}
+
+	execute(get(), "a", dag.RunFailed)
+	resumed := get()
+	resumed.Status, resumed.Resumes = dag.RunRunning, 1
+	if claimed, err := store.ClaimRun(ctx, resumed, dag.RunFailed, 0); err != nil || !claimed {
+		t.Fatalf("ClaimRun: %v, %v", claimed, err)
+	}
+	execute(resumed, "b", dag.RunSucceeded)
+
+	run := get()
+	if run.Status != dag.RunSucceeded {
+		t.Fatalf("run %s, want succeeded", run.Status)
+	}
+	for _, id := range []string{"a", "b"} {
+		if run.Results[id] != strings.ToUpper(id) {
+			t.Errorf("result of %s = %v, want %s", id, run.Results[id], strings.ToUpper(id))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -26,13 +27,17 @@ func NewManagerService(mongoURI string) *ManagerService {
 }
 
 // SaveDAG stores a DAG definition in MongoDB
-func (m *ManagerService) SaveDAG(dag *dag.DAG) error {
+func (m *ManagerService) SaveDAG(ctx context.Context, d *dag.DAG) error {
+	if errs := dag.Validate(d); len(errs) > 0 {
+		return dag.ValidationErrors(errs)
+	}
 	collection := "dags"
 	uuid, err := uuid.NewRandom()
 	if err != nil {
 		return fmt.Errorf("failed to generate UUID: %w", err)
 	}
-	marshalDag, err := json.Marshal(dag)
+	d.Version = 1
+	marshalDag, err := json.Marshal(d)
 	if err != nil {
 		return fmt.Errorf("failed to marshal input schema: %w", err)
 	}
@@ -42,7 +47,7 @@ func (m *ManagerService) SaveDAG(dag *dag.DAG) error {
 	}
 	json.Unmarshal(marshalDag, &data)
 
-	r, err := m.db.Create(collection, data)
+	r, err := m.db.Create(ctx, collection, data, nil)
 	fmt.Println(err, r)
 	if err != nil {
 		return fmt.Errorf("failed to save DAG: %w", err)
@@ -51,13 +56,13 @@ func (m *ManagerService) SaveDAG(dag *dag.DAG) error {
 }
 
 // GetDAG retrieves a DAG definition by ID
-func (m *ManagerService) GetDAG(id string) (*dag.DAG, error) {
+func (m *ManagerService) GetDAG(ctx context.Context, id string) (*dag.DAG, error) {
 	collection := "dags"
 	filter := map[string]interface{}{
 		"id": id,
 	}
 
-	results, err := m.db.Retrieve(collection, []string{}, filter)
+	results, err := m.db.Retrieve(ctx, collection, []string{}, filter, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve DAG: %w", err)
 	}
@@ -65,10 +70,48 @@ func (m *ManagerService) GetDAG(id string) (*dag.DAG, error) {
 	if len(results) == 0 {
 		return nil, fmt.Errorf("DAG not found: %s", id)
 	}
+	return decodeDAG(results[0])
+}
 
+// LoadDAG retrieves the given version of a DAG definition, the latest one for
+// version 0. Earlier versions are kept in the dag_versions collection.
+func (m *ManagerService) LoadDAG(ctx context.Context, id string, version int) (*dag.DAG, error) {
+	current, err := m.GetDAG(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 || version == dagVersion(current) {
+		return current, nil
+	}
+
+	filter := map[string]interface{}{
+		"id":      id,
+		"version": version,
+	}
+	results, err := m.db.Retrieve(ctx, "dag_versions", []string{}, filter, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve DAG version: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("DAG %s has no version %d", id, version)
+	}
+	return decodeDAG(results[0])
+}
+
+// dagVersion returns the version of a stored DAG, DAGs saved before versions
+// were recorded count as version 1
+func dagVersion(d *dag.DAG) int {
+	if d.Version == 0 {
+		return 1
+	}
+	return d.Version
+}
+
+// decodeDAG converts a stored document into a DAG
+func decodeDAG(result interface{}) (*dag.DAG, error) {
 	// First unmarshal to a map to handle the MongoDB document structure
 	var rawData map[string]interface{}
-	bsonBytes, err := bson.Marshal(results[0])
+	bsonBytes, err := bson.Marshal(result)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal BSON: %w", err)
 	}
@@ -92,9 +135,9 @@ func (m *ManagerService) GetDAG(id string) (*dag.DAG, error) {
 }
 
 // ListDAGs retrieves all stored DAG definitions
-func (m *ManagerService) ListDAGs() ([]dag.DAG, error) {
+func (m *ManagerService) ListDAGs(ctx context.Context) ([]dag.DAG, error) {
 	collection := "dags"
-	results, err := m.db.Retrieve(collection, []string{}, map[string]interface{}{})
+	results, err := m.db.Retrieve(ctx, collection, []string{}, map[string]interface{}{}, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list DAGs: %w", err)
 	}
@@ -117,27 +160,47 @@ func (m *ManagerService) ListDAGs() ([]dag.DAG, error) {
 }
 
 // DeleteDAG removes a DAG definition by ID
-func (m *ManagerService) DeleteDAG(id string) error {
+func (m *ManagerService) DeleteDAG(ctx context.Context, id string) error {
 	collection := "dags"
 	filter := map[string]interface{}{
 		"id": id,
 	}
 
-	_, err := m.db.Delete(collection, filter)
+	_, err := m.db.Delete(ctx, collection, filter, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete DAG: %w", err)
 	}
+	if _, err := m.db.Delete(ctx, "dag_versions", filter, nil); err != nil {
+		return fmt.Errorf("failed to delete DAG versions: %w", err)
+	}
 	return nil
 }
 
-// UpdateDAG updates an existing DAG definition
-func (m *ManagerService) UpdateDAG(dag *dag.DAG) (interface{}, error) {
+// UpdateDAG updates an existing DAG definition as a new version, keeping the
+// previous one so running and referring DAGs can still load it
+func (m *ManagerService) UpdateDAG(ctx context.Context, d *dag.DAG) (interface{}, error) {
+	if errs := dag.Validate(d); len(errs) > 0 {
+		return nil, dag.ValidationErrors(errs)
+	}
 	collection := "dags"
 	filter := map[string]interface{}{
-		"id": dag.ID,
+		"id": d.ID,
 	}
 
-	marshalDag, err := json.Marshal(dag)
+	previous, err := m.GetDAG(ctx, d.ID)
+	if err != nil {
+		return nil, err
+	}
+	previous.Version = dagVersion(previous)
+	marshalPrevious, err := json.Marshal(previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal previous version: %w", err)
+	}
+	archived := map[string]interface{}{}
+	json.Unmarshal(marshalPrevious, &archived)
+	d.Version = previous.Version + 1
+
+	marshalDag, err := json.Marshal(d)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal input schema: %w", err)
 	}
@@ -145,9 +208,13 @@ func (m *ManagerService) UpdateDAG(dag *dag.DAG) (interface{}, error) {
 	data := map[string]interface{}{}
 	json.Unmarshal(marshalDag, &data)
 
-	r, err := m.db.Update(collection, data, filter)
+	r, err := m.db.Update(ctx, collection, data, filter, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update DAG: %w", err)
 	}
+	// only archived once it was replaced, so every archived version was current
+	if _, err := m.db.Create(ctx, "dag_versions", archived, nil); err != nil {
+		return nil, fmt.Errorf("failed to archive DAG version %d: %w", previous.Version, err)
+	}
 	return r, nil
 }
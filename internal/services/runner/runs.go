@@ -0,0 +1,361 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	dag "github.com/lynnphayu/dag-runner/pkg/dag"
+)
+
+var (
+	// ErrNoRunStore is returned by the run methods when the service was created
+	// without a run store
+	ErrNoRunStore = errors.New("no run store configured")
+	// ErrRunNotActive is returned when cancelling a run that is not executing
+	// in this process
+	ErrRunNotActive = errors.New("run is not executing")
+	// ErrRunNotResumable is returned when resuming a run that succeeded or is
+	// still executing
+	ErrRunNotResumable = errors.New("run cannot be resumed")
+	// ErrInvalidRerun is returned when a re-run names steps the DAG does not have
+	ErrInvalidRerun = errors.New("invalid re-run")
+)
+
+// StartRun validates the DAG, records a new run and executes it in the
+// background. The returned run is the record as it was created.
+func (r *RunnerService) StartRun(ctx context.Context, dagID string, d *dag.DAG, input map[string]interface{}) (*dag.Run, error) {
+	if r.runs == nil {
+		return nil, ErrNoRunStore
+	}
+	if errs := dag.Validate(d); len(errs) > 0 {
+		return nil, dag.ValidationErrors(errs)
+	}
+	if err := r.executor.CheckSchema(ctx, d); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	trace := dag.NewTrace(d)
+	run := &dag.Run{
+		ID:        id.String(),
+		DAGID:     dagID,
+		Status:    dag.RunRunning,
+		DAG:       d,
+		Input:     input,
+		CreatedAt: time.Now(),
+		Trace:     trace.Copy(),
+	}
+	if err := r.runs.CreateRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	active, _ := r.activate(run.ID, trace)
+	r.launch(run, active)
+	return run, nil
+}
+
+// ResumeRun continues a run that did not succeed, for instance because a step
+// failed or the process executing it died. Steps that succeeded keep their
+// checkpointed results and are not executed again. The run must not be
+// executing in any other process either.
+func (r *RunnerService) ResumeRun(ctx context.Context, id string) (*dag.Run, error) {
+	if r.runs == nil {
+		return nil, ErrNoRunStore
+	}
+	run, err := r.runs.GetRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status == dag.RunSucceeded {
+		return nil, fmt.Errorf("%w: run %s already succeeded", ErrRunNotResumable, id)
+	}
+	if run.Status == dag.RunCompensated || run.Status == dag.RunCompensationFailed {
+		return nil, fmt.Errorf("%w: the completed steps of run %s were compensated", ErrRunNotResumable, id)
+	}
+	if run.DAG == nil {
+		return nil, fmt.Errorf("%w: run %s has no recorded DAG", ErrRunNotResumable, id)
+	}
+
+	trace := run.Trace
+	if trace == nil {
+		trace = dag.NewTrace(run.DAG)
+	}
+	active, ok := r.activate(run.ID, trace)
+	if !ok {
+		return nil, fmt.Errorf("%w: run %s is still executing", ErrRunNotResumable, id)
+	}
+
+	status, resumes := run.Status, run.Resumes
+	now := time.Now()
+	run.Status = dag.RunRunning
+	run.Output, run.Error, run.ErrorKind, run.FinishedAt = nil, "", "", nil
+	run.CancelledBy, run.CancelledAt = "", nil
+	run.ResumedAt, run.Resumes = &now, resumes+1
+	run.Trace = trace.Copy()
+	claimed, err := r.runs.ClaimRun(ctx, run, status, resumes)
+	if err != nil || !claimed {
+		r.deactivate(run.ID, active)
+		active.cancel(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, fmt.Errorf("%w: run %s was resumed by another process", ErrRunNotResumable, id)
+	}
+
+	r.launch(run, active, dag.WithResults(run.SucceededResults()))
+	return run, nil
+}
+
+// RerunRun starts a new run of d that re-runs only the given steps and their
+// descendants. Every other step reuses its result from the previous run
+// instead of querying or posting again, so d may be a fixed version of the
+// DAG the previous run executed.
+func (r *RunnerService) RerunRun(ctx context.Context, previousID string, d *dag.DAG, from []string) (*dag.Run, error) {
+	if r.runs == nil {
+		return nil, ErrNoRunStore
+	}
+	if errs := dag.Validate(d); len(errs) > 0 {
+		return nil, dag.ValidationErrors(errs)
+	}
+	if err := r.executor.CheckSchema(ctx, d); err != nil {
+		return nil, err
+	}
+	affected, err := dag.AffectedSteps(d, from)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRerun, err)
+	}
+
+	previous, err := r.runs.GetRun(ctx, previousID)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Status == dag.RunRunning {
+		return nil, fmt.Errorf("%w: run %s is still running", ErrRunNotResumable, previousID)
+	}
+
+	// the new run owns the reused results so it can be resumed in turn
+	results := previous.SucceededResults()
+	for _, stepID := range affected {
+		delete(results, stepID)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate run ID: %w", err)
+	}
+	trace := dag.NewTrace(d)
+	run := &dag.Run{
+		ID:        id.String(),
+		DAGID:     previous.DAGID,
+		Status:    dag.RunRunning,
+		DAG:       d,
+		Input:     previous.Input,
+		CreatedAt: time.Now(),
+		Results:   results,
+		RerunOf:   previous.ID,
+		RerunFrom: from,
+		Trace:     trace.Copy(),
+	}
+	if err := r.runs.CreateRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	active, _ := r.activate(run.ID, trace)
+	r.launch(run, active, dag.WithResults(results), dag.WithRerunFrom(from...))
+	return run, nil
+}
+
+// activate registers a run as executing in this process, unless it already is
+func (r *RunnerService) activate(id string, trace *dag.Trace) (*activeRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.active[id]; ok {
+		return nil, false
+	}
+	// the run outlives the request that started it
+	ctx, cancel := context.WithCancelCause(context.Background())
+	active := &activeRun{ctx: ctx, cancel: cancel, trace: trace, subscribers: make(map[chan dag.Event]struct{})}
+	r.active[id] = active
+	return active, true
+}
+
+// deactivate unregisters a run and closes the streams of its subscribers
+func (r *RunnerService) deactivate(id string, active *activeRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, id)
+	for ch := range active.subscribers {
+		close(ch)
+	}
+	active.subscribers = nil
+}
+
+// launch executes a registered run in the background
+func (r *RunnerService) launch(run *dag.Run, active *activeRun, opts ...dag.RunOption) {
+	background := *run
+	go r.execute(&background, active, opts...)
+}
+
+// execute runs the DAG of a launched run, checkpointing every step, and
+// records its outcome
+func (r *RunnerService) execute(run *dag.Run, active *activeRun, opts ...dag.RunOption) {
+	defer func() {
+		r.deactivate(run.ID, active)
+		active.cancel(nil)
+	}()
+
+	opts = append(opts, dag.WithRunID(run.ID), dag.WithTrace(active.trace), dag.WithCheckpointer(r.runs))
+	result, err := r.executor.ExecuteWithTrace(active.ctx, run.DAG, run.Input, opts...)
+	run.Finish(result, err)
+	r.mu.Lock()
+	run.CancelledBy, run.CancelledAt = active.cancelledBy, active.cancelledAt
+	r.mu.Unlock()
+	if err := r.runs.UpdateRun(context.Background(), run); err != nil {
+		// record the run as failed rather than leave it running
+		run.Output = nil
+		run.Finish(nil, fmt.Errorf("failed to record run: %w", err))
+		if err := r.runs.UpdateRun(context.Background(), run); err != nil {
+			log.Printf("failed to record run %s: %v", run.ID, err)
+		}
+	}
+}
+
+// GetRun returns a run record, with the live step states of runs that are
+// still executing in this process
+func (r *RunnerService) GetRun(ctx context.Context, id string) (*dag.Run, error) {
+	if r.runs == nil {
+		return nil, ErrNoRunStore
+	}
+	run, err := r.runs.GetRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	active, ok := r.active[id]
+	if ok {
+		run.CancelledBy, run.CancelledAt = active.cancelledBy, active.cancelledAt
+	}
+	r.mu.Unlock()
+	if ok {
+		run.Trace = active.trace.Copy()
+	}
+	return run, nil
+}
+
+// CancelRun cancels a run executing in this process on behalf of by. In-flight
+// steps are aborted and no further steps start; the run is recorded as
+// cancelled once its steps have wound down.
+func (r *RunnerService) CancelRun(ctx context.Context, id string, by string) error {
+	if r.runs == nil {
+		return ErrNoRunStore
+	}
+
+	r.mu.Lock()
+	active, ok := r.active[id]
+	if ok && active.cancelledAt == nil {
+		now := time.Now()
+		active.cancelledBy, active.cancelledAt = by, &now
+	}
+	r.mu.Unlock()
+	if ok {
+		active.cancel(dag.ErrRunCancelled)
+		return nil
+	}
+
+	run, err := r.runs.GetRun(ctx, id)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: run %s is %s", ErrRunNotActive, id, run.Status)
+}
+
+// ListRuns returns the recorded runs matching filter
+func (r *RunnerService) ListRuns(ctx context.Context, filter dag.RunFilter) ([]dag.Run, error) {
+	if r.runs == nil {
+		return nil, ErrNoRunStore
+	}
+	return r.runs.ListRuns(ctx, filter)
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped
+const subscriberBuffer = 64
+
+// OnEvent forwards the events of runs started by this service to their
+// subscribers. A subscriber that falls behind is dropped rather than
+// blocking the run; it can resubscribe and gets the events replayed.
+func (r *RunnerService) OnEvent(event dag.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	active, ok := r.active[event.RunID]
+	if !ok {
+		return
+	}
+	active.events = append(active.events, event)
+	for ch := range active.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(active.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe streams the events of a run, starting with the ones it has
+// already emitted. The channel is closed when the run finishes; call the
+// returned function to stop listening earlier. A run that finished before
+// subscribing yields only its run.finished event.
+func (r *RunnerService) Subscribe(ctx context.Context, id string) (<-chan dag.Event, func(), error) {
+	if r.runs == nil {
+		return nil, nil, ErrNoRunStore
+	}
+
+	r.mu.Lock()
+	active, ok := r.active[id]
+	if ok {
+		ch := make(chan dag.Event, len(active.events)+subscriberBuffer)
+		for _, event := range active.events {
+			ch <- event
+		}
+		active.subscribers[ch] = struct{}{}
+		r.mu.Unlock()
+
+		unsubscribe := func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if _, ok := active.subscribers[ch]; ok {
+				delete(active.subscribers, ch)
+				close(ch)
+			}
+		}
+		return ch, unsubscribe, nil
+	}
+	r.mu.Unlock()
+
+	run, err := r.runs.GetRun(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if run.Status == dag.RunRunning {
+		return nil, nil, fmt.Errorf("%w: run %s is not running in this process", ErrRunNotActive, id)
+	}
+	ch := make(chan dag.Event, 1)
+	finished := dag.Event{Type: dag.EventRunFinished, RunID: run.ID, Status: run.Status, Error: run.Error, ErrorKind: run.ErrorKind}
+	if run.FinishedAt != nil {
+		finished.Time = *run.FinishedAt
+	}
+	ch <- finished
+	close(ch)
+	return ch, func() {}, nil
+}
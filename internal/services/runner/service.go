@@ -1,7 +1,10 @@
 package runner
 
 import (
+	"context"
 	"log"
+	"sync"
+	"time"
 
 	httpClient "github.com/lynnphayu/dag-runner/internal/repositories/http"
 	postgres "github.com/lynnphayu/dag-runner/internal/repositories/postgres"
@@ -11,35 +14,71 @@ import (
 type RunnerService struct {
 	executor *dag.Executor
 	db       *postgres.Postgres
+	runs     dag.RunStore
+
+	mu     sync.Mutex
+	active map[string]*activeRun
+}
+
+// activeRun is a run executing in this process
+type activeRun struct {
+	ctx         context.Context
+	cancel      context.CancelCauseFunc
+	trace       *dag.Trace
+	cancelledBy string
+	cancelledAt *time.Time
+	events      []dag.Event // replayed to late subscribers
+	subscribers map[chan dag.Event]struct{}
 }
 
-func NewRunnerService(connString string) *RunnerService {
+// NewRunnerService creates a runner. runs may be nil when asynchronous runs
+// are not needed.
+func NewRunnerService(connString string, runs dag.RunStore, opts ...dag.ExecutorOption) *RunnerService {
 	persistent, err := postgres.NewPostgres(connString)
 	if err != nil {
 		log.Fatalf("failed to create postgres: %v", err)
 	}
+	return NewRunnerServiceWithDB(persistent, runs, opts...)
+}
+
+// NewRunnerServiceWithDB creates a runner on an existing database connection,
+// e.g. one that also keeps the runs
+func NewRunnerServiceWithDB(persistent *postgres.Postgres, runs dag.RunStore, opts ...dag.ExecutorOption) *RunnerService {
 	httpClient, err := httpClient.NewHttp()
 	if err != nil {
 		log.Fatalf("failed to create http: %v", err)
 	}
-	executor, err := dag.NewExecutor(persistent, httpClient)
+	service := &RunnerService{
+		db:     persistent,
+		runs:   runs,
+		active: make(map[string]*activeRun),
+	}
+	executor, err := dag.NewExecutor(persistent, httpClient, append(opts, dag.WithObserver(service))...)
 	if err != nil {
 		log.Fatalf("failed to create executor: %v", err)
 	}
-	return &RunnerService{
-		executor,
-		persistent,
-	}
+	service.executor = executor
+	return service
+}
+
+func (r *RunnerService) Execute(ctx context.Context, dag *dag.DAG, input map[string]interface{}) (interface{}, error) {
+	return r.executor.ExecuteContext(ctx, dag, input)
+}
+
+// ExecuteWithTrace executes the DAG and returns the per-step trace with the output
+func (r *RunnerService) ExecuteWithTrace(ctx context.Context, dag *dag.DAG, input map[string]interface{}) (*dag.Result, error) {
+	return r.executor.ExecuteWithTrace(ctx, dag, input)
 }
 
-func (r *RunnerService) Execute(dag *dag.DAG, input map[string]interface{}) (interface{}, error) {
-	return r.executor.Execute(dag, input)
+// Plan reports what executing the DAG with input would do without running it
+func (r *RunnerService) Plan(dag *dag.DAG, input map[string]interface{}) (*dag.Plan, error) {
+	return r.executor.Plan(dag, input)
 }
 
-func (r *RunnerService) GetTableNames() ([]string, error) {
-	return r.db.GetTableNames()
+func (r *RunnerService) GetTableNames(ctx context.Context) ([]string, error) {
+	return r.db.GetTableNames(ctx)
 }
 
-func (r *RunnerService) GetColumns(tableName string) (map[string]string, error) {
-	return r.db.GetColumns(tableName)
+func (r *RunnerService) GetColumns(ctx context.Context, tableName string) (map[string]string, error) {
+	return r.db.GetColumns(ctx, tableName)
 }
@@ -0,0 +1,252 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrAccessDenied is returned for a database step its DAG's access rules
+	// do not allow
+	ErrAccessDenied = errors.New("access denied")
+	// ErrUnknownTable is returned by SchemaChecker for a table the database does not have
+	ErrUnknownTable = errors.New("unknown table")
+	// ErrUnknownColumn is returned by SchemaChecker for a column the table does not have
+	ErrUnknownColumn = errors.New("unknown column")
+)
+
+// Access restricts what the database steps of a DAG may do. A stored DAG run
+// by a dag step is held to the rules of its parents as well as its own.
+type Access struct {
+	// ReadOnly only allows query steps
+	ReadOnly bool `json:"readOnly,omitempty" bson:"readOnly,omitempty"`
+	// Operations lists the database step types allowed, every one when empty
+	Operations []StepType `json:"operations,omitempty" bson:"operations,omitempty"`
+	// Tables maps the tables allowed to the columns allowed in them, every
+	// column when the list is empty. Every table is allowed when it is nil.
+	Tables map[string][]string `json:"tables,omitempty" bson:"tables,omitempty"`
+}
+
+// SchemaChecker is implemented by Persist backends that can check the tables
+// and columns of database steps against the database before running them
+type SchemaChecker interface {
+	CheckIdentifiers(ctx context.Context, table string, columns []string) error
+}
+
+// checkOperation returns an error unless the access rules allow steps of type op
+func (a *Access) checkOperation(op StepType) error {
+	if a == nil {
+		return nil
+	}
+	if (a.ReadOnly && op != Query) || (len(a.Operations) > 0 && !slices.Contains(a.Operations, op)) {
+		return fmt.Errorf("%w: %s steps are not allowed", ErrAccessDenied, op)
+	}
+	return nil
+}
+
+// checkColumns returns an error unless the access rules allow the table and
+// every one of its columns. "*" stands for every column.
+func (a *Access) checkColumns(table string, columns []string) error {
+	if a == nil || a.Tables == nil {
+		return nil
+	}
+	allowed, ok := a.Tables[table]
+	if !ok {
+		return fmt.Errorf("%w: table %s is not allowed", ErrAccessDenied, table)
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, column := range columns {
+		if column == "*" {
+			return fmt.Errorf("%w: only the columns %s of table %s may be selected", ErrAccessDenied, strings.Join(allowed, ", "), table)
+		}
+		if !slices.Contains(allowed, column) {
+			return fmt.Errorf("%w: column %s of table %s is not allowed", ErrAccessDenied, column, table)
+		}
+	}
+	return nil
+}
+
+// authorize checks a database step against the access rules of the execution
+// and of every DAG running it, once its where and data are resolved
+func (e *Execution) authorize(step *Step, where, data map[string]interface{}) error {
+	var columns []string
+	for _, field := range identifiers(step, where, data) {
+		columns = append(columns, field.columns...)
+	}
+	for _, access := range e.access {
+		if err := access.checkOperation(step.Type); err != nil {
+			return err
+		}
+		if err := access.checkColumns(step.Table, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldColumns are the columns named by a single field of a step
+type fieldColumns struct {
+	field   string
+	columns []string
+}
+
+// identifiers returns the columns a database step names, by field. A query
+// selecting no columns in particular selects "*".
+func identifiers(step *Step, where, data map[string]interface{}) []fieldColumns {
+	fields := make([]fieldColumns, 0, 3)
+	if step.Type == Query {
+		selected := step.Select
+		if len(selected) == 0 {
+			selected = []string{"*"}
+		}
+		fields = append(fields, fieldColumns{"select", selected})
+	}
+	if step.Type != Insert {
+		fields = append(fields, fieldColumns{"where", WhereColumns(where)})
+	}
+	switch step.Type {
+	case Insert:
+		fields = append(fields, fieldColumns{"map", sortedKeys(data)}, fieldColumns{"returning", step.Returning})
+	case Update:
+		fields = append(fields, fieldColumns{"set", sortedKeys(data)}, fieldColumns{"returning", step.Returning})
+	case Delete:
+		fields = append(fields, fieldColumns{"returning", step.Returning})
+	case Query:
+		orderBy := make([]string, len(step.OrderBy))
+		for i, order := range step.OrderBy {
+			orderBy[i] = order.Column
+		}
+		fields = append(fields, fieldColumns{"orderBy", orderBy})
+	}
+	return fields
+}
+
+// declaredIdentifiers returns the columns a database step names before any of
+// its values are resolved
+func declaredIdentifiers(step *Step) []fieldColumns {
+	data := step.Params.Map
+	if step.Type == Update {
+		data = step.Set
+	}
+	return identifiers(step, step.Where, data)
+}
+
+// WhereColumns returns the columns a where condition names, including the
+// ones nested in $and, $or and $not, in sorted order
+func WhereColumns(where map[string]interface{}) []string {
+	seen := make(map[string]bool)
+	var walk func(where map[string]interface{})
+	walk = func(where map[string]interface{}) {
+		for key, value := range where {
+			switch key {
+			case "$and", "$or":
+				switch list := value.(type) {
+				case []interface{}:
+					for _, item := range list {
+						if nested, ok := item.(map[string]interface{}); ok {
+							walk(nested)
+						}
+					}
+				case []map[string]interface{}:
+					for _, nested := range list {
+						walk(nested)
+					}
+				}
+			case "$not":
+				if nested, ok := value.(map[string]interface{}); ok {
+					walk(nested)
+				}
+			default:
+				seen[key] = true
+			}
+		}
+	}
+	walk(where)
+	return sortedKeys(seen)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isDatabaseStep reports whether steps of type t read or write the database
+func isDatabaseStep(t StepType) bool {
+	switch t {
+	case Query, Insert, Update, Delete:
+		return true
+	}
+	return false
+}
+
+// CheckSchema checks the tables and columns every database step of the DAG
+// names, including the steps of sub-graphs and compensate steps, against the
+// database when it is a SchemaChecker. Unknown identifiers are returned as
+// ValidationErrors.
+func (e *Executor) CheckSchema(ctx context.Context, dag *DAG) error {
+	checker, ok := (*e.db).(SchemaChecker)
+	if !ok {
+		return nil
+	}
+	var errs ValidationErrors
+	check := func(stepID, field string, table string, columns []string) (bool, error) {
+		err := checker.CheckIdentifiers(ctx, table, columns)
+		if errors.Is(err, ErrUnknownTable) || errors.Is(err, ErrUnknownColumn) {
+			errs = append(errs, ValidationError{StepID: stepID, Field: field, Code: CodeUnknownIdentifier, Message: err.Error()})
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to check the identifiers of step %s: %w", stepID, err)
+		}
+		return true, nil
+	}
+	checkStep := func(stepID string, step *Step) error {
+		if !isDatabaseStep(step.Type) {
+			return nil
+		}
+		if ok, err := check(stepID, "table", step.Table, nil); !ok {
+			return err
+		}
+		for _, field := range declaredIdentifiers(step) {
+			if _, err := check(stepID, field.field, step.Table, field.columns); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var walk func(prefix string, steps []Step) error
+	walk = func(prefix string, steps []Step) error {
+		for i := range steps {
+			step := &steps[i]
+			if err := checkStep(prefix+step.ID, step); err != nil {
+				return err
+			}
+			if step.Compensate != nil {
+				if err := checkStep(prefix+step.ID+".compensate", step.Compensate); err != nil {
+					return err
+				}
+			}
+			if err := walk(prefix+step.ID+".", step.Steps); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", dag.Steps); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CompensationError is returned by a failed execution that ran the
+// compensations of its completed steps
+type CompensationError struct {
+	Err      error   // why the execution failed
+	Failures []error // compensations that failed themselves
+}
+
+func (e *CompensationError) Error() string {
+	if len(e.Failures) == 0 {
+		return fmt.Sprintf("%v (compensated)", e.Err)
+	}
+	messages := make([]string, len(e.Failures))
+	for i, err := range e.Failures {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%v (compensation failed: %s)", e.Err, strings.Join(messages, "; "))
+}
+
+func (e *CompensationError) Unwrap() error {
+	return e.Err
+}
+
+// compensate undoes the completed steps of a failed execution by running
+// their compensate steps one at a time, a step only after the steps depending
+// on it. Every compensation runs even when an earlier one failed. Cancelled
+// executions and steps outside a partial re-run are not compensated.
+func (e *Execution) compensate(err error) error {
+	if ErrorKindOf(err) == ErrorCancelled {
+		return err
+	}
+	waves, _ := e.graph.waves()
+	compensated := false
+	failures := make([]error, 0)
+	for i := len(waves) - 1; i >= 0; i-- {
+		for j := len(waves[i]) - 1; j >= 0; j-- {
+			step := e.stepsMap[waves[i][j]]
+			if step.Compensate == nil || (e.only != nil && !e.only[step.ID]) {
+				continue
+			}
+			if trace, ok := e.trace.Step(step.ID); !ok || trace.Status != StepSucceeded {
+				continue
+			}
+			compensated = true
+			if err := e.compensateStep(step); err != nil {
+				failures = append(failures, err)
+			}
+		}
+	}
+	if !compensated {
+		return err
+	}
+	return &CompensationError{Err: err, Failures: failures}
+}
+
+// compensateStep runs the compensate step of a completed step under its own
+// timeout and retry policy, even when the run's context is done, and records
+// the outcome under compensation in the step's trace
+func (e *Execution) compensateStep(step *Step) error {
+	compensation := *step.Compensate
+	compensation.ID = step.ID
+	sub := &DAG{ID: e.dag.ID, Steps: []Step{compensation}}
+	child, err := e.child(context.WithoutCancel(e.ctx), sub, e.input, e.results.snapshot(), e.vars)
+	if err != nil {
+		return err
+	}
+
+	ctx := child.ctx
+	if timeout := parseDuration(compensation.Timeout, e.executor.stepTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrStepTimeout)
+		defer cancel()
+	}
+	child.trace.startStep(step.ID)
+	_, err = child.executeWithRetry(ctx, &compensation, e.stepContext())
+	if err != nil {
+		err = newStepError(child.ctx, ctx, step.ID, err)
+	}
+	child.trace.finishStep(step.ID, err)
+
+	trace, _ := child.trace.Step(step.ID)
+	e.trace.compensateStep(step.ID, &trace)
+	if err != nil {
+		var stepErr *StepError
+		if errors.As(err, &stepErr) {
+			return fmt.Errorf("compensation of step %s: %w", step.ID, stepErr.Err)
+		}
+		return err
+	}
+	return nil
+}
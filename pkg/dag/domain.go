@@ -7,7 +7,13 @@ type DAG struct {
 	Description string `json:"description,omitempty" bson:"description,omitempty"`
 	InputSchema Schema `json:"inputSchema,omitempty" bson:"inputSchema,omitempty"`
 	// OutputSchema Schema `json:"outputSchema,omitempty" bson:"outputSchema,omitempty"`
-	Steps []Step `json:"steps" bson:"steps"`
+	Steps   []Step `json:"steps" bson:"steps"`
+	Timeout string `json:"timeout,omitempty" bson:"timeout,omitempty"` // duration bounding the whole run, e.g. "2m"
+	Version int    `json:"version,omitempty" bson:"version,omitempty"` // bumped by every update of a stored DAG
+	// MaxParallel bounds how many steps of a run are dispatched at once
+	MaxParallel int `json:"maxParallel,omitempty" bson:"maxParallel,omitempty"`
+	// Access restricts the tables, columns and operations of database steps
+	Access *Access `json:"access,omitempty" bson:"access,omitempty"`
 }
 
 // Schema represents a JSON schema for input/output validation
@@ -21,16 +27,20 @@ type Schema struct {
 type StepType string
 
 const (
-	Query  StepType = "query"
-	Insert StepType = "insert"
-	Update StepType = "update"
-	Delete StepType = "delete"
-	Cond   StepType = "condition"
-	HTTP   StepType = "http"
-	Map    StepType = "map"
-	Join   StepType = "join"
-	Filter StepType = "filter"
-	Output StepType = "output"
+	Query       StepType = "query"
+	Insert      StepType = "insert"
+	Update      StepType = "update"
+	Delete      StepType = "delete"
+	Cond        StepType = "condition"
+	Switch      StepType = "switch"
+	ForEach     StepType = "foreach"
+	SubDAG      StepType = "dag"
+	Transaction StepType = "transaction"
+	HTTP        StepType = "http"
+	Map         StepType = "map"
+	Join        StepType = "join"
+	Filter      StepType = "filter"
+	Output      StepType = "output"
 )
 
 // Step represents a single step in the DAG
@@ -43,6 +53,58 @@ type Step struct {
 	Then      []string `json:"then,omitempty" bson:"then,omitempty"` // next steps
 	DependsOn []string `json:"dependsOn,omitempty" bson:"dependsOn,omitempty"`
 	// Output    interface{} `json:"output,omitempty" bson:"output,omitempty"`
+	Retry   *RetryPolicy `json:"retry,omitempty" bson:"retry,omitempty"`
+	Timeout string       `json:"timeout,omitempty" bson:"timeout,omitempty"` // duration bounding the step including retries, e.g. "30s"
+	OnError *ErrorPolicy `json:"onError,omitempty" bson:"onError,omitempty"`
+	// TriggerRule decides whether the step runs once all of its predecessors
+	// have finished or been skipped
+	TriggerRule TriggerRule `json:"triggerRule,omitempty" bson:"triggerRule,omitempty"`
+	// Compensate undoes the step, e.g. a DELETE after a POST, when the run
+	// fails after the step completed. It reads $results like any other step.
+	Compensate *Step `json:"compensate,omitempty" bson:"compensate,omitempty"`
+	// Resources names the executor resource pools the step holds while it
+	// runs, next to the ones its type implies. The steps of a foreach, dag or
+	// transaction step run within them.
+	Resources []string `json:"resources,omitempty" bson:"resources,omitempty"`
+}
+
+type TriggerRule string
+
+// A step whose predecessors were all skipped is skipped under every rule, so
+// the branch a condition did not take is skipped all the way down
+const (
+	TriggerAllSuccess TriggerRule = "all-success" // every predecessor succeeded (default)
+	TriggerOneSuccess TriggerRule = "one-success" // at least one predecessor succeeded
+	TriggerAllDone    TriggerRule = "all-done"    // every predecessor finished, failed or not
+	TriggerNoneFailed TriggerRule = "none-failed" // no predecessor failed, skipped ones are ignored
+)
+
+type ErrorAction string
+
+const (
+	OnErrorFail     ErrorAction = "fail"     // fail the run (default)
+	OnErrorContinue ErrorAction = "continue" // record the error and release the next steps
+	OnErrorFallback ErrorAction = "fallback" // use Fallback as the step result
+	OnErrorGoto     ErrorAction = "goto"     // release only the Goto catch steps
+)
+
+// ErrorPolicy decides what happens when a step still fails after its retries
+type ErrorPolicy struct {
+	Action   ErrorAction `json:"action" bson:"action"`
+	Fallback interface{} `json:"fallback,omitempty" bson:"fallback,omitempty"` // static value or $ expression
+	Goto     []string    `json:"goto,omitempty" bson:"goto,omitempty"`         // catch steps, the error is in $errors.<stepId>
+}
+
+// RetryPolicy controls how a failing step is retried. Delays are duration
+// strings such as "250ms" or "2s".
+type RetryPolicy struct {
+	MaxAttempts   int      `json:"maxAttempts" bson:"maxAttempts"`                         // total attempts including the first
+	InitialDelay  string   `json:"initialDelay,omitempty" bson:"initialDelay,omitempty"`   // defaults to 100ms
+	Multiplier    float64  `json:"multiplier,omitempty" bson:"multiplier,omitempty"`       // defaults to 2
+	MaxDelay      string   `json:"maxDelay,omitempty" bson:"maxDelay,omitempty"`           // caps the backoff
+	Jitter        float64  `json:"jitter,omitempty" bson:"jitter,omitempty"`               // 0-1, fraction of the delay randomised
+	RetryOn       []string `json:"retryOn,omitempty" bson:"retryOn,omitempty"`             // error substrings, empty retries any error
+	RetryOnStatus []int    `json:"retryOnStatus,omitempty" bson:"retryOnStatus,omitempty"` // HTTP status codes treated as failures
 }
 
 type Params struct {
@@ -51,6 +113,9 @@ type Params struct {
 	FilterParams
 	MapParams
 	ConditionParams
+	SwitchParams
+	ForEachParams
+	SubDAGParams
 	HTTPParams
 	OutputParams
 }
@@ -68,10 +133,18 @@ type DbOperationParams struct {
 	InsertParams
 	UpdateParams
 	DeleteParams
+	MutationParams
 }
 
+// QueryParams picks the columns of a query and how its rows are sorted and
+// paged. With a limit the result is {"rows": [...], "nextCursor": ...}, where
+// nextCursor is passed as after to fetch the next page, or null on the last one.
 type QueryParams struct {
-	Select []string `json:"select" bson:"select"`
+	Select  []string `json:"select" bson:"select"`
+	OrderBy []Order  `json:"orderBy,omitempty" bson:"orderBy,omitempty"`
+	Limit   int      `json:"limit,omitempty" bson:"limit,omitempty"`
+	Offset  int      `json:"offset,omitempty" bson:"offset,omitempty"`
+	After   string   `json:"after,omitempty" bson:"after,omitempty"` // cursor of the page to start after, may be a $ expression
 }
 type InsertParams struct {
 	Map map[string]interface{} `json:"map" bson:"map"`
@@ -81,6 +154,16 @@ type UpdateParams struct {
 }
 type DeleteParams struct{}
 
+// MutationParams guard the rows an update or delete step changes. The result
+// of an insert, update or delete step is the number of rows changed or, with
+// returning columns, {"count": n, "rows": [...]} holding those columns of the
+// rows.
+type MutationParams struct {
+	AllowAll        bool     `json:"allowAll,omitempty" bson:"allowAll,omitempty"`               // an empty where changes every row
+	MaxAffectedRows int      `json:"maxAffectedRows,omitempty" bson:"maxAffectedRows,omitempty"` // roll back beyond this many rows
+	Returning       []string `json:"returning,omitempty" bson:"returning,omitempty"`
+}
+
 type JoinType string
 
 const (
@@ -92,7 +175,7 @@ const (
 
 type JoinParams struct {
 	On    map[string]string `json:"on" bson:"on"`
-	Type  JoinType          `json:"type" bson:"type"`
+	Type  JoinType          `json:"joinType,omitempty" bson:"joinType,omitempty"` // defaults to inner; "type" is taken by the step type
 	Left  string            `json:"left" bson:"left"`
 	Right string            `json:"right" bson:"right"`
 }
@@ -101,8 +184,14 @@ type FilterParams struct {
 	Filter map[string]interface{} `json:"filter" bson:"filter"`
 }
 
+// MapParams transforms every row of a list into a new row. Function and the
+// values of Fields are expr-lang expressions evaluated with row, index, input
+// and results in scope; a leading $ is optional.
 type MapParams struct {
-	Function string `json:"function" bson:"function"`
+	Function string            `json:"function,omitempty" bson:"function,omitempty"` // expression producing the whole row
+	Fields   map[string]string `json:"fields,omitempty" bson:"fields,omitempty"`     // output field -> expression
+	Items    string            `json:"items,omitempty" bson:"items,omitempty"`       // list to map over, defaults to the single dependency's result
+	Flatten  bool              `json:"flatten,omitempty" bson:"flatten,omitempty"`   // splice list results into the output
 }
 
 type ConditionParams struct {
@@ -110,6 +199,37 @@ type ConditionParams struct {
 	Else []string  `json:"else" bson:"else"`
 }
 
+// SwitchParams routes to the first case whose When equals Expression. Without
+// an expression every When is a boolean expression and the first true one wins.
+type SwitchParams struct {
+	Expression string       `json:"expression,omitempty" bson:"expression,omitempty"`
+	Cases      []SwitchCase `json:"cases,omitempty" bson:"cases,omitempty"`
+	Default    []string     `json:"default,omitempty" bson:"default,omitempty"` // steps run when no case matches
+}
+
+type SwitchCase struct {
+	When interface{} `json:"when" bson:"when"` // static value or $ expression
+	Then []string    `json:"then" bson:"then"`
+}
+
+// ForEachParams runs a sub-graph once per item of the list in Items, with
+// $item and $index in scope. The sub-graph is either the inline Steps, which
+// also see the input and the results of the enclosing DAG, or the stored DAG
+// of SubDAGParams which gets Input, or the item when there is none, as its
+// input.
+type ForEachParams struct {
+	Steps       []Step `json:"steps,omitempty" bson:"steps,omitempty"`             // also the block of a transaction step
+	Concurrency int    `json:"concurrency,omitempty" bson:"concurrency,omitempty"` // items running at once, defaults to 1
+	FailFast    bool   `json:"failFast,omitempty" bson:"failFast,omitempty"`       // stop the remaining items after the first failure
+}
+
+// SubDAGParams refers to a stored DAG run as a child of the current run
+type SubDAGParams struct {
+	DAGID   string                 `json:"dagId,omitempty" bson:"dagId,omitempty"`
+	Version int                    `json:"version,omitempty" bson:"version,omitempty"` // defaults to the latest version
+	Input   map[string]interface{} `json:"input,omitempty" bson:"input,omitempty"`     // input of the child run, values may be $ expressions
+}
+
 type SupportedHTTPMethods string
 
 const (
@@ -0,0 +1,74 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorKind tells apart why a step did not succeed
+type ErrorKind string
+
+const (
+	ErrorFailed    ErrorKind = "failed"
+	ErrorTimeout   ErrorKind = "timeout"
+	ErrorCancelled ErrorKind = "cancelled"
+)
+
+var (
+	// ErrStepTimeout is the cause of a step context whose timeout elapsed
+	ErrStepTimeout = errors.New("step timed out")
+	// ErrRunTimeout is the cause of a run context whose timeout elapsed
+	ErrRunTimeout = errors.New("run timed out")
+	// ErrRunCancelled is the cause of a run context cancelled on request
+	ErrRunCancelled = errors.New("run cancelled")
+)
+
+// StepError is returned when a step does not succeed
+type StepError struct {
+	StepID string
+	Kind   ErrorKind
+	Err    error
+}
+
+func (e *StepError) Error() string {
+	switch e.Kind {
+	case ErrorTimeout:
+		return fmt.Sprintf("step %s timed out: %v", e.StepID, e.Err)
+	case ErrorCancelled:
+		return fmt.Sprintf("step %s cancelled: %v", e.StepID, e.Err)
+	default:
+		return fmt.Sprintf("step %s failed: %v", e.StepID, e.Err)
+	}
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKindOf returns the kind of a step error, or ErrorFailed for any other error
+func ErrorKindOf(err error) ErrorKind {
+	var stepErr *StepError
+	if errors.As(err, &stepErr) {
+		return stepErr.Kind
+	}
+	if errors.Is(err, ErrRunTimeout) || errors.Is(err, ErrStepTimeout) {
+		return ErrorTimeout
+	}
+	if errors.Is(err, ErrRunCancelled) || errors.Is(err, context.Canceled) {
+		return ErrorCancelled
+	}
+	return ErrorFailed
+}
+
+// newStepError classifies err using the contexts the step ran under
+func newStepError(runCtx, stepCtx context.Context, stepID string, err error) *StepError {
+	kind := ErrorFailed
+	switch {
+	case errors.Is(context.Cause(stepCtx), ErrStepTimeout), errors.Is(context.Cause(runCtx), ErrRunTimeout):
+		kind = ErrorTimeout
+	case stepCtx.Err() != nil:
+		kind = ErrorCancelled
+	}
+	return &StepError{StepID: stepID, Kind: kind, Err: err}
+}
@@ -0,0 +1,75 @@
+package dag
+
+import "time"
+
+type EventType string
+
+const (
+	EventRunStarted    EventType = "run.started"
+	EventRunFinished   EventType = "run.finished"
+	EventStepQueued    EventType = "step.queued"
+	EventStepStarted   EventType = "step.started"
+	EventStepSucceeded EventType = "step.succeeded"
+	EventStepFailed    EventType = "step.failed"
+	EventStepSkipped   EventType = "step.skipped"
+)
+
+// Event reports a change in the progress of an execution
+type Event struct {
+	Type      EventType `json:"type"`
+	RunID     string    `json:"runId,omitempty"`
+	StepID    string    `json:"stepId,omitempty"`
+	Time      time.Time `json:"time"`
+	Status    RunStatus `json:"status,omitempty"` // outcome of a finished run
+	Error     string    `json:"error,omitempty"`
+	ErrorKind ErrorKind `json:"errorKind,omitempty"`
+}
+
+// Observer receives the events of every execution of an executor. OnEvent is
+// called synchronously from the scheduler, so it must not block.
+type Observer interface {
+	OnEvent(event Event)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// WithObserver registers an observer for the events of every execution
+func WithObserver(observer Observer) ExecutorOption {
+	return func(e *Executor) {
+		e.observers = append(e.observers, observer)
+	}
+}
+
+// WithRunID tags the events of an execution with the ID of its run
+func WithRunID(id string) RunOption {
+	return func(c *runConfig) {
+		c.runID = id
+	}
+}
+
+// emit stamps an event and hands it to the executor's observers
+func (e *Execution) emit(event Event) {
+	if len(e.executor.observers) == 0 || e.nested {
+		return
+	}
+	event.RunID = e.runID
+	event.Time = time.Now()
+	for _, observer := range e.executor.observers {
+		observer.OnEvent(event)
+	}
+}
+
+// emitFinished reports the outcome of an execution
+func (e *Execution) emitFinished(err error) {
+	event := Event{Type: EventRunFinished, Status: runStatus(err)}
+	if err != nil {
+		event.Error = err.Error()
+		event.ErrorKind = ErrorKindOf(err)
+	}
+	e.emit(event)
+}
@@ -1,56 +1,150 @@
 package dag
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sync"
+	"time"
 
 	"github.com/xeipuuv/gojsonschema"
 )
 
 type Persist interface {
-	Create(table string, data map[string]interface{}) (interface{}, error)
-	Retrieve(table string, select_ []string, where map[string]interface{}) ([]interface{}, error)
-	Update(table string, data map[string]interface{}, where map[string]interface{}) (interface{}, error)
-	Delete(table string, where map[string]interface{}) (interface{}, error)
+	// Create inserts a row and returns the number of rows inserted, or
+	// MutationResult with the returning columns of the inserted row
+	Create(ctx context.Context, table string, data map[string]interface{}, returning []string) (interface{}, error)
+	// Retrieve returns the matching rows, sorted and paged by page unless it is nil
+	Retrieve(ctx context.Context, table string, select_ []string, where map[string]interface{}, page *Page) ([]interface{}, error)
+	// Update and Delete change the matching rows within the bounds of mutation,
+	// refusing a where mutation.CheckWhere rejects, and return their count, or
+	// MutationResult with their Returning columns
+	Update(ctx context.Context, table string, data map[string]interface{}, where map[string]interface{}, mutation *Mutation) (interface{}, error)
+	Delete(ctx context.Context, table string, where map[string]interface{}, mutation *Mutation) (interface{}, error)
 
-	GetTableNames() ([]string, error)
-	GetColumns(table string) (map[string]string, error)
+	GetTableNames(ctx context.Context) ([]string, error)
+	GetColumns(ctx context.Context, table string) (map[string]string, error)
+
+	// Begin starts a transaction, a savepoint when called on a Tx
+	Begin(ctx context.Context) (Tx, error)
+}
+
+// Tx is a Persist whose operations take effect together on Commit
+type Tx interface {
+	Persist
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
 }
 
 type ParsedResponse struct {
 	Data       interface{}
-	Raw        *http.Response
+	Raw        *http.Response `json:"-" bson:"-"` // not stored with runs, its request cannot be encoded
 	StatusCode int
 }
 
 type Http interface {
-	Post(url string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
-	Get(url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
-	Put(url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
-	Delete(url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
-	Patch(url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+	Post(ctx context.Context, url string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+	Get(ctx context.Context, url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+	Put(ctx context.Context, url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+	Delete(ctx context.Context, url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+	Patch(ctx context.Context, url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error)
+}
+
+// DAGLoader looks up stored DAGs that steps refer to by ID
+type DAGLoader interface {
+	// LoadDAG returns the given version of a DAG, the latest one for version 0
+	LoadDAG(ctx context.Context, id string, version int) (*DAG, error)
 }
 
 // Executor handles the execution of a DAG with parallel processing capabilities
 type Executor struct {
 	db         *Persist
 	httpClient *Http
+
+	stepTimeout time.Duration
+	runTimeout  time.Duration
+	observers   []Observer
+	dags        DAGLoader
+	maxDepth    int
+	slots       chan struct{}            // steps running across all runs
+	maxParallel int                      // steps dispatched at once per run
+	pools       map[string]chan struct{} // resource name -> steps holding it
+}
+
+// ExecutorOption configures optional executor behaviour
+type ExecutorOption func(*Executor)
+
+// WithStepTimeout bounds every step that does not set its own timeout
+func WithStepTimeout(d time.Duration) ExecutorOption {
+	return func(e *Executor) {
+		e.stepTimeout = d
+	}
+}
+
+// WithRunTimeout bounds every run whose DAG does not set its own timeout
+func WithRunTimeout(d time.Duration) ExecutorOption {
+	return func(e *Executor) {
+		e.runTimeout = d
+	}
+}
+
+// WithDAGLoader lets steps run stored DAGs looked up with loader
+func WithDAGLoader(loader DAGLoader) ExecutorOption {
+	return func(e *Executor) {
+		e.dags = loader
+	}
+}
+
+// WithMaxDepth bounds how deeply DAGs may run other stored DAGs, defaults to
+// DefaultMaxDepth
+func WithMaxDepth(depth int) ExecutorOption {
+	return func(e *Executor) {
+		e.maxDepth = depth
+	}
 }
 
 // NewExecutor creates a new DAG executor
-func NewExecutor(db Persist, http Http) (*Executor, error) {
-	return &Executor{
+func NewExecutor(db Persist, http Http, opts ...ExecutorOption) (*Executor, error) {
+	executor := &Executor{
 		db:         &db,
 		httpClient: &http,
-	}, nil
+		maxDepth:   DefaultMaxDepth,
+	}
+	for _, opt := range opts {
+		opt(executor)
+	}
+	return executor, nil
 }
 
 // Execute runs the DAG with parallel execution of steps
 func (e *Executor) Execute(dag *DAG, input map[string]interface{}) (interface{}, error) {
-	if err := validateSchema(dag.InputSchema, input); err != nil {
-		return nil, fmt.Errorf("input validation failed: %w", err)
+	return e.ExecuteContext(context.Background(), dag, input)
+}
+
+// ExecuteContext runs the DAG like Execute but stops scheduling steps and aborts
+// in-flight ones once ctx is cancelled or its deadline passes
+func (e *Executor) ExecuteContext(ctx context.Context, dag *DAG, input map[string]interface{}) (interface{}, error) {
+	result, err := e.ExecuteWithTrace(ctx, dag, input)
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
+
+// ExecuteWithTrace runs the DAG like ExecuteContext and also returns the trace
+// of every step, including retried attempts. The trace is returned alongside
+// the error when a step fails.
+func (e *Executor) ExecuteWithTrace(ctx context.Context, dag *DAG, input map[string]interface{}, opts ...RunOption) (*Result, error) {
+	config := &runConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if errs := Validate(dag); len(errs) > 0 {
+		return nil, ValidationErrors(errs)
+	}
+	if err := e.CheckSchema(ctx, dag); err != nil {
+		return nil, err
 	}
 
 	stepsMap, err := e.mapSteps(dag)
@@ -58,39 +152,78 @@ func (e *Executor) Execute(dag *DAG, input map[string]interface{}) (interface{},
 		return nil, err
 	}
 
+	graph, err := buildGraph(dag, stepsMap)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.rerunFrom) > 0 {
+		if err := config.rerun(graph); err != nil {
+			return nil, err
+		}
+	}
+
+	if timeout := parseDuration(dag.Timeout, e.runTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrRunTimeout)
+		defer cancel()
+	}
+	if config.trace == nil {
+		config.trace = NewTrace(dag)
+	}
+	config.trace.restore(dag, config.restored)
+
 	execution := &Execution{
+		ctx:      ctx,
+		runID:    config.runID,
 		dag:      dag,
 		stepsMap: stepsMap,
-		context: &Context{
-			Results: &map[string]interface{}{},
-			Input:   &input,
-		},
-		executor:          e,
-		waitList:          &sync.Map{},
-		wg:                &sync.WaitGroup{},
-		errorChannel:      make(chan ErrEvt, 1),
-		completionChannel: make(chan string, 100),
+		graph:    graph,
+		input:    input,
+		results:  newResults(),
+		errors:   newResults(),
+		trace:    config.trace,
+		executor: e,
+
+		restored:     config.restored,
+		only:         config.only,
+		checkpointer: config.checkpointer,
+		db:           *e.db,
+		access:       []*Access{dag.Access},
+		stack:        []string{dag.ID},
 	}
 
-	// Create wait group for tracking goroutines
+	execution.emit(Event{Type: EventRunStarted})
+	result, err := execution.execute()
+	execution.emitFinished(err)
+	return result, err
+}
 
-	// Start execution from the entry step
-	independentSteps := e.independentSteps(dag)
-	for _, step := range independentSteps {
-		execution.wg.Add(1)
-		go execution.initExecution(execution.stepsMap[step])
+// execute validates the input, runs every step and validates the output. A
+// failed execution compensates its completed steps.
+func (e *Execution) execute() (*Result, error) {
+	if err := validateSchema(e.dag.InputSchema, e.input); err != nil {
+		return nil, fmt.Errorf("input validation failed: %w", err)
 	}
 
-	execution.wg.Wait()
-
-	// Check for any errors
-	select {
-	case err := <-execution.errorChannel:
-		return nil, fmt.Errorf("step %s failed: %w", err.StepID, err.Err)
-	default:
-		// No errors occurred
+	err := e.run()
+	var output interface{}
+	if err == nil {
+		output, err = e.output()
+	}
+	if err != nil {
+		err = e.compensate(err)
+	}
+	e.trace.finish()
+	result := &Result{Trace: e.trace}
+	if err != nil {
+		return result, err
 	}
+	result.Output = output
+	return result, nil
+}
 
+// output returns the result of the output step validated against its schema
+func (e *Execution) output() (interface{}, error) {
 	// Get the final step result
 	// result, ok := (*execution.context.Results)[dag.Result]
 	// if !ok {
@@ -99,26 +232,18 @@ func (e *Executor) Execute(dag *DAG, input map[string]interface{}) (interface{},
 
 	// result := resolveString[interface{}](dag.Result, execution.context)
 	// Find the output step
-	var outputStepID string
-	for _, step := range dag.Steps {
-		if step.Name == "output" {
-			outputStepID = step.ID
-			break
-		}
-	}
-
-	if outputStepID == "" {
+	outputStep := outputStep(e.dag)
+	if outputStep == nil {
 		return nil, fmt.Errorf("output step not found")
 	}
-	outputStep := execution.stepsMap[outputStepID]
-	fmt.Println(execution.output)
+	output, _ := e.results.load(outputStep.ID)
+	fmt.Println(output)
 	fmt.Println(outputStep.Schema)
 	// Validate output against schema
-	if err := validateSchema(outputStep.Schema, execution.output); err != nil {
+	if err := validateSchema(outputStep.Schema, output); err != nil {
 		return nil, fmt.Errorf("output validation failed: %w", err)
 	}
-
-	return execution.output.(interface{}), nil
+	return output, nil
 }
 
 func (e *Executor) mapSteps(dag *DAG) (map[string]*Step, error) {
@@ -132,26 +257,6 @@ func (e *Executor) mapSteps(dag *DAG) (map[string]*Step, error) {
 	return steps, nil
 }
 
-func (e *Executor) independentSteps(dag *DAG) []string {
-	dependentSteps := make(map[string]bool)
-	independentSteps := make([]string, 0)
-	for _, step := range dag.Steps {
-		for _, dep := range step.Then {
-			dependentSteps[dep] = true
-		}
-		// only for Condition type
-		for _, dep := range step.Else {
-			dependentSteps[dep] = true
-		}
-	}
-	for _, step := range dag.Steps {
-		if !dependentSteps[step.ID] && len(step.DependsOn) == 0 {
-			independentSteps = append(independentSteps, step.ID)
-		}
-	}
-	return independentSteps
-}
-
 func ParseDAG(dagString []byte) (DAG, error) {
 	var dag DAG
 	err := json.Unmarshal([]byte(dagString), &dag)
@@ -0,0 +1,111 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// executeForEach runs the step's sub-graph once per item and returns the
+// outputs in item order. Up to Concurrency items run at once. Without
+// FailFast every item runs and the failures are reported together, with it
+// the first failure cancels the items still running.
+func (e *Execution) executeForEach(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	items, err := toRows(resolveV2[interface{}](step.Items, state))
+	if err != nil {
+		return nil, fmt.Errorf("foreach step items: %w", err)
+	}
+	sub, err := e.forEachDAG(ctx, step)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	concurrency := step.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+	outputs := make([]interface{}, len(items))
+	failures := make([]error, len(items))
+	var wg sync.WaitGroup
+	for i, item := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, item interface{}) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			output, err := e.runItem(ctx, step, sub, state, i, item)
+			if err != nil {
+				failures[i] = fmt.Errorf("item %d: %w", i, err)
+				if step.FailFast {
+					cancel(failures[i])
+				}
+				return
+			}
+			outputs[i] = output
+		}(i, item)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		// the first failure of a failFast step or the step's own cancellation
+		return nil, context.Cause(ctx)
+	}
+	if err := errors.Join(failures...); err != nil {
+		return nil, err
+	}
+	return outputs, nil
+}
+
+// forEachDAG returns the sub-graph a foreach step runs per item. Inline steps
+// share the ID and input schema of the enclosing DAG since they see its input.
+func (e *Execution) forEachDAG(ctx context.Context, step *Step) (*DAG, error) {
+	if step.DAGID == "" {
+		return &DAG{ID: e.dag.ID, InputSchema: e.dag.InputSchema, Steps: step.Steps}, nil
+	}
+	return e.loadDAG(ctx, step.DAGID, step.Version)
+}
+
+// runItem executes the sub-graph for a single item and returns its output
+func (e *Execution) runItem(ctx context.Context, step *Step, sub *DAG, state *Context, index int, item interface{}) (interface{}, error) {
+	vars := make(map[string]interface{}, len(state.Vars)+2)
+	for name, value := range state.Vars {
+		vars[name] = value
+	}
+	vars["item"] = item
+	vars["index"] = index
+
+	input := *state.Input
+	inherited := *state.Results
+	if step.DAGID != "" {
+		inherited = nil
+		if step.Input != nil {
+			input = resolveValues(step.Input, &Context{Input: state.Input, Results: state.Results, Errors: state.Errors, Vars: vars}).(map[string]interface{})
+		} else if row, ok := item.(map[string]interface{}); ok {
+			input = row
+		} else {
+			return nil, fmt.Errorf("DAG %s needs an object as input but the item is %T", step.DAGID, item)
+		}
+	}
+
+	execution, err := e.child(ctx, sub, input, inherited, vars)
+	if err != nil {
+		return nil, err
+	}
+	execution.held = e.holding(step)
+	result, err := execution.execute()
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
 	"github.com/tidwall/gjson"
 )
 
@@ -35,7 +36,7 @@ func performJoin(datasets [][]map[string]interface{}, on map[string]string, join
 	// Perform join
 	result := make([]map[string]interface{}, 0)
 	switch joinType {
-	case Inner:
+	case Inner, "":
 		for _, leftRow := range left {
 			for _, rightRow := range right {
 				if matchJoinConditions(leftRow, rightRow, on) {
@@ -105,6 +106,84 @@ func applyFilter(data interface{}, conditions map[string]interface{}) (interface
 	return result, nil
 }
 
+// toRows normalises the list shapes produced by the different step types
+func toRows(data interface{}) ([]interface{}, error) {
+	switch v := data.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case []interface{}:
+		return v, nil
+	case []map[string]interface{}:
+		rows := make([]interface{}, len(v))
+		for i, row := range v {
+			rows[i] = row
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("expected a list but got %T", data)
+	}
+}
+
+// compileRowExpression compiles a per-row expression, the leading $ is optional
+func compileRowExpression(src string) (*vm.Program, error) {
+	return expr.Compile(strings.TrimPrefix(src, "$"))
+}
+
+// applyMap evaluates the map function, or every field expression, once per row
+// with row and index added to env
+func applyMap(rows []interface{}, params MapParams, env map[string]interface{}) ([]interface{}, error) {
+	var function *vm.Program
+	fields := make(map[string]*vm.Program, len(params.Fields))
+	if params.Function != "" {
+		program, err := compileRowExpression(params.Function)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile map function: %w", err)
+		}
+		function = program
+	}
+	for field, src := range params.Fields {
+		program, err := compileRowExpression(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile map field %s: %w", field, err)
+		}
+		fields[field] = program
+	}
+
+	result := make([]interface{}, 0, len(rows))
+	for i, row := range rows {
+		env["row"] = row
+		env["index"] = i
+
+		var mapped interface{}
+		if function != nil {
+			value, err := expr.Run(function, env)
+			if err != nil {
+				return nil, fmt.Errorf("map function failed on row %d: %w", i, err)
+			}
+			mapped = value
+		} else {
+			item := make(map[string]interface{}, len(fields))
+			for field, program := range fields {
+				value, err := expr.Run(program, env)
+				if err != nil {
+					return nil, fmt.Errorf("map field %s failed on row %d: %w", field, i, err)
+				}
+				item[field] = value
+			}
+			mapped = item
+		}
+
+		if params.Flatten {
+			if nested, err := toRows(mapped); err == nil {
+				result = append(result, nested...)
+				continue
+			}
+		}
+		result = append(result, mapped)
+	}
+	return result, nil
+}
+
 // matchConditions checks if an item matches filter conditions
 func matchConditions(item interface{}, conditions map[string]interface{}) bool {
 	for key, condition := range conditions {
@@ -268,6 +347,8 @@ func resolveValues(input interface{}, context *Context) interface{} {
 				resolvedMap[key] = resolveValues(obj, context)
 			} else if slice, ok := value.([]map[string]interface{}); ok {
 				resolvedMap[key] = resolveValues(slice, context)
+			} else if slice, ok := value.([]interface{}); ok {
+				resolvedMap[key] = resolveValues(slice, context)
 			} else {
 				resolvedMap[key] = value
 			}
@@ -283,8 +364,12 @@ func resolveValues(input interface{}, context *Context) interface{} {
 	case []interface{}:
 		resolvedSlice := make([]interface{}, len(v))
 		for i, item := range v {
-			resolvedItem := resolveValues(item, context)
-			resolvedSlice[i] = resolvedItem
+			if str, ok := item.(string); ok {
+				// list items resolve like map values, e.g. the conditions of $or
+				resolvedSlice[i] = resolveV2[interface{}](str, context)
+				continue
+			}
+			resolvedSlice[i] = resolveValues(item, context)
 		}
 		return resolvedSlice
 	case string:
@@ -308,6 +393,10 @@ func resolveV2[T []map[string]T | map[string]T | string | bool | int | interface
 	env := map[string]interface{}{
 		"input":   context.Input,
 		"results": context.Results,
+		"errors":  context.Errors,
+	}
+	for name, value := range context.Vars {
+		env[name] = value
 	}
 
 	// Handle string interpolation with ${var} syntax
@@ -340,6 +429,11 @@ func resolveV2[T []map[string]T | map[string]T | string | bool | int | interface
 			if converted, ok := result.(T); ok {
 				return converted
 			}
+			// a missing value, such as an absent field of $item
+			if result == nil {
+				var zero T
+				return zero
+			}
 			// Try string conversion for bool/int types
 			return any(result).(T)
 		} else {
@@ -0,0 +1,108 @@
+package dag
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var (
+	// ErrEmptyWhere is returned for an update or delete without conditions
+	// that is not allowed to change every row
+	ErrEmptyWhere = errors.New("empty where would change every row")
+	// ErrUnboundedWhere is returned for an update or delete whose where has an
+	// empty in or notin list, which under notin or $not matches every row
+	ErrUnboundedWhere = errors.New("where could change every row")
+	// ErrTooManyRows is returned, and the change rolled back, when an update or
+	// delete changes more rows than its maxAffectedRows
+	ErrTooManyRows = errors.New("too many rows affected")
+)
+
+// Mutation bounds the rows an update or delete changes and picks what it
+// returns: the number of rows changed, or that number together with their
+// Returning columns
+type Mutation struct {
+	AllowAll        bool     // an empty where changes every row
+	MaxAffectedRows int      // 0 for no limit
+	Returning       []string // "*" for every column
+}
+
+// mutation returns the mutation an update or delete step asks for
+func mutation(step *Step) *Mutation {
+	return &Mutation{
+		AllowAll:        step.AllowAll,
+		MaxAffectedRows: step.MaxAffectedRows,
+		Returning:       step.Returning,
+	}
+}
+
+// CheckWhere returns ErrEmptyWhere for an empty where and ErrUnboundedWhere
+// for a where with an empty in or notin list, unless the mutation allows
+// changing every row. A nil mutation allows none.
+func (m *Mutation) CheckWhere(where map[string]interface{}) error {
+	if m != nil && m.AllowAll {
+		return nil
+	}
+	if len(where) == 0 {
+		return fmt.Errorf("%w; set allowAll to allow it", ErrEmptyWhere)
+	}
+	if column, ok := emptyList(where); ok {
+		return fmt.Errorf("%w: %s has an empty in or notin list; set allowAll to allow it", ErrUnboundedWhere, column)
+	}
+	return nil
+}
+
+// emptyList returns the first column of where, including the ones nested in
+// $and, $or and $not, given an empty in or notin list
+func emptyList(where map[string]interface{}) (string, bool) {
+	for _, key := range sortedKeys(where) {
+		value := where[key]
+		switch key {
+		case "$and", "$or":
+			list := reflect.ValueOf(value)
+			if list.Kind() != reflect.Slice {
+				continue
+			}
+			for i := 0; i < list.Len(); i++ {
+				if nested, ok := list.Index(i).Interface().(map[string]interface{}); ok {
+					if column, ok := emptyList(nested); ok {
+						return column, true
+					}
+				}
+			}
+		case "$not":
+			if nested, ok := value.(map[string]interface{}); ok {
+				if column, ok := emptyList(nested); ok {
+					return column, true
+				}
+			}
+		default:
+			operators, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, op := range []string{"in", "notin"} {
+				list := reflect.ValueOf(operators[op])
+				if list.Kind() == reflect.Slice && list.Len() == 0 {
+					return key, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// MutationResult is the result of an insert, update or delete with returning
+// columns: the number of rows changed and the returned rows
+func MutationResult(count int64, rows []interface{}) map[string]interface{} {
+	return map[string]interface{}{"count": count, "rows": rows}
+}
+
+// CheckAffected returns ErrTooManyRows when more rows were changed than the
+// mutation allows
+func (m *Mutation) CheckAffected(affected int64) error {
+	if m != nil && m.MaxAffectedRows > 0 && affected > int64(m.MaxAffectedRows) {
+		return fmt.Errorf("%w: %d rows, at most %d allowed", ErrTooManyRows, affected, m.MaxAffectedRows)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package dag
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckWhere(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutation *Mutation
+		where    map[string]interface{}
+		err      error
+	}{
+		{name: "where", where: map[string]interface{}{"id": 1}},
+		{name: "in", where: map[string]interface{}{"id": map[string]interface{}{"in": []interface{}{1}}}},
+		{name: "empty where", where: map[string]interface{}{}, err: ErrEmptyWhere},
+		{name: "nil where", where: nil, err: ErrEmptyWhere},
+		{name: "empty where allowed", mutation: &Mutation{AllowAll: true}, where: map[string]interface{}{}},
+		{name: "empty notin", where: map[string]interface{}{"id": map[string]interface{}{"notin": []interface{}{}}}, err: ErrUnboundedWhere},
+		{name: "empty typed notin", where: map[string]interface{}{"id": map[string]interface{}{"notin": []string{}}}, err: ErrUnboundedWhere},
+		{name: "negated empty in", where: map[string]interface{}{"$not": map[string]interface{}{"id": map[string]interface{}{"in": []interface{}{}}}}, err: ErrUnboundedWhere},
+		{
+			name:  "nested empty notin",
+			where: map[string]interface{}{"$or": []interface{}{map[string]interface{}{"status": "new"}, map[string]interface{}{"id": map[string]interface{}{"notin": []interface{}{}}}}},
+			err:   ErrUnboundedWhere,
+		},
+		{name: "empty notin allowed", mutation: &Mutation{AllowAll: true}, where: map[string]interface{}{"id": map[string]interface{}{"notin": []interface{}{}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mutation.CheckWhere(tt.where); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckAffected(t *testing.T) {
+	tests := []struct {
+		name     string
+		mutation *Mutation
+		affected int64
+		err      error
+	}{
+		{name: "nil mutation", affected: 100},
+		{name: "no limit", mutation: &Mutation{}, affected: 100},
+		{name: "within limit", mutation: &Mutation{MaxAffectedRows: 2}, affected: 2},
+		{name: "over limit", mutation: &Mutation{MaxAffectedRows: 2}, affected: 3, err: ErrTooManyRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.mutation.CheckAffected(tt.affected); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
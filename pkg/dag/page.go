@@ -0,0 +1,96 @@
+package dag
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Page orders the rows a query returns and narrows them down to one page
+type Page struct {
+	OrderBy []Order
+	Limit   int // 0 for every row
+	Offset  int
+	// After holds the OrderBy values of the last row already seen; only the
+	// rows sorting after it are returned
+	After []interface{}
+}
+
+// Order sorts query rows by a column
+type Order struct {
+	Column string `json:"column" bson:"column"`
+	Desc   bool   `json:"desc,omitempty" bson:"desc,omitempty"`
+}
+
+// queryPage returns the page a query step asks for, nil when it does not
+// sort or page its rows. cursor is the resolved after param.
+func queryPage(step *Step, cursor interface{}) (*Page, error) {
+	if len(step.OrderBy) == 0 && step.Limit == 0 && step.Offset == 0 {
+		return nil, nil
+	}
+	page := &Page{OrderBy: step.OrderBy, Limit: step.Limit, Offset: step.Offset}
+	if cursor == nil || cursor == "" {
+		return page, nil
+	}
+	str, ok := cursor.(string)
+	if !ok {
+		return nil, fmt.Errorf("cursor must be a string, got %T", cursor)
+	}
+	after, err := decodeCursor(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(after) != len(step.OrderBy) {
+		return nil, fmt.Errorf("cursor has %d values but the query orders by %d columns", len(after), len(step.OrderBy))
+	}
+	page.After = after
+	return page, nil
+}
+
+// pageResult wraps the rows of a query step with a limit together with the
+// cursor of the next page, nil on the last page
+func pageResult(page *Page, rows []interface{}) (interface{}, error) {
+	result := map[string]interface{}{"rows": rows, "nextCursor": nil}
+	if len(page.OrderBy) == 0 || len(rows) < page.Limit {
+		return result, nil
+	}
+	last, ok := rows[len(rows)-1].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot build a cursor from a %T row", rows[len(rows)-1])
+	}
+	values := make([]interface{}, len(page.OrderBy))
+	for i, order := range page.OrderBy {
+		value, ok := last[order.Column]
+		if !ok {
+			return nil, fmt.Errorf("orderBy column %s must be selected to build the next cursor", order.Column)
+		}
+		values[i] = value
+	}
+	cursor, err := encodeCursor(values)
+	if err != nil {
+		return nil, err
+	}
+	result["nextCursor"] = cursor
+	return result, nil
+}
+
+// encodeCursor packs the sort values of a row into an opaque string
+func encodeCursor(values []interface{}) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return values, nil
+}
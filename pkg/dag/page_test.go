@@ -0,0 +1,97 @@
+package dag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	step := query("users", "users")
+	step.OrderBy = []Order{{Column: "createdAt", Desc: true}, {Column: "id"}}
+	step.Limit = 2
+
+	first, err := queryPage(&step, nil)
+	if err != nil {
+		t.Fatalf("queryPage: %v", err)
+	}
+	rows := []interface{}{
+		map[string]interface{}{"id": float64(9), "createdAt": "2024-01-02"},
+		map[string]interface{}{"id": float64(4), "createdAt": "2024-01-01"},
+	}
+	result, err := pageResult(first, rows)
+	if err != nil {
+		t.Fatalf("pageResult: %v", err)
+	}
+	cursor := result.(map[string]interface{})["nextCursor"]
+	if cursor == nil {
+		t.Fatal("full page without a next cursor")
+	}
+
+	next, err := queryPage(&step, cursor)
+	if err != nil {
+		t.Fatalf("queryPage: %v", err)
+	}
+	if want := []interface{}{"2024-01-01", float64(4)}; !reflect.DeepEqual(next.After, want) {
+		t.Errorf("got after %v, want %v", next.After, want)
+	}
+
+	last, err := pageResult(next, rows[:1])
+	if err != nil {
+		t.Fatalf("pageResult: %v", err)
+	}
+	if cursor := last.(map[string]interface{})["nextCursor"]; cursor != nil {
+		t.Errorf("got next cursor %v on the last page", cursor)
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	valid, err := encodeCursor([]interface{}{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		orderBy []Order
+		limit   int
+		cursor  interface{}
+		page    *Page
+		err     string
+	}{
+		{name: "no paging", page: nil},
+		{name: "first page", orderBy: []Order{{Column: "id"}}, limit: 10, page: &Page{OrderBy: []Order{{Column: "id"}}, Limit: 10}},
+		{name: "empty cursor", orderBy: []Order{{Column: "id"}}, limit: 10, cursor: "", page: &Page{OrderBy: []Order{{Column: "id"}}, Limit: 10}},
+		{name: "cursor", orderBy: []Order{{Column: "id"}}, limit: 10, cursor: valid, page: &Page{OrderBy: []Order{{Column: "id"}}, Limit: 10, After: []interface{}{float64(1)}}},
+		{name: "not a string", orderBy: []Order{{Column: "id"}}, limit: 10, cursor: 1, err: "cursor must be a string"},
+		{name: "not base64", orderBy: []Order{{Column: "id"}}, limit: 10, cursor: "!!", err: "invalid cursor"},
+		{name: "not a list", orderBy: []Order{{Column: "id"}}, limit: 10, cursor: "e30", err: "invalid cursor"},
+		{name: "other columns", orderBy: []Order{{Column: "id"}, {Column: "name"}}, limit: 10, cursor: valid, err: "cursor has 1 values but the query orders by 2 columns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := query("users", "users")
+			step.OrderBy, step.Limit = tt.orderBy, tt.limit
+			page, err := queryPage(&step, tt.cursor)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("queryPage: %v", err)
+			}
+			if !reflect.DeepEqual(page, tt.page) {
+				t.Errorf("got %+v, want %+v", page, tt.page)
+			}
+		})
+	}
+}
+
+func TestPageResultUnselectedColumn(t *testing.T) {
+	page := &Page{OrderBy: []Order{{Column: "id"}}, Limit: 1}
+	_, err := pageResult(page, []interface{}{map[string]interface{}{"name": "a"}})
+	if err == nil || !strings.Contains(err.Error(), "orderBy column id must be selected") {
+		t.Fatalf("got %v, want the unselected column", err)
+	}
+}
@@ -0,0 +1,267 @@
+package dag
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// QueryPlanner is implemented by Persist backends that can show the statement
+// an operation would run without running it
+type QueryPlanner interface {
+	PlanRetrieve(table string, select_ []string, where map[string]interface{}, page *Page) (string, []interface{}, error)
+	PlanCreate(table string, data map[string]interface{}, returning []string) (string, []interface{}, error)
+	PlanUpdate(table string, data map[string]interface{}, where map[string]interface{}, mutation *Mutation) (string, []interface{}, error)
+	PlanDelete(table string, where map[string]interface{}, mutation *Mutation) (string, []interface{}, error)
+}
+
+// Plan describes what executing a DAG with a given input would do
+type Plan struct {
+	Waves [][]string `json:"waves"` // steps that may run in parallel, in execution order
+	Steps []StepPlan `json:"steps"`
+}
+
+// StepPlan is the planned work of a single step. Expressions reading the
+// results or errors of other steps are only known at run time and are kept
+// as written, listed under Unresolved.
+type StepPlan struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	Type       StepType  `json:"type"`
+	Wave       int       `json:"wave"`
+	DependsOn  []string  `json:"dependsOn,omitempty"`
+	SQL        *SQLPlan  `json:"sql,omitempty"`
+	HTTP       *HTTPPlan `json:"http,omitempty"`
+	Unresolved []string  `json:"unresolved,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// SQLPlan is a statement with its bound arguments
+type SQLPlan struct {
+	Query string        `json:"query"`
+	Args  []interface{} `json:"args"`
+}
+
+// HTTPPlan is the request an HTTP step would send
+type HTTPPlan struct {
+	Method  SupportedHTTPMethods   `json:"method"`
+	URL     string                 `json:"url"`
+	Query   map[string]interface{} `json:"query,omitempty"`
+	Body    map[string]interface{} `json:"body,omitempty"`
+	Headers map[string]string      `json:"headers,omitempty"`
+}
+
+// Plan resolves every param that only depends on the input and reports the
+// SQL and HTTP requests the steps would issue and the waves they would run in,
+// without touching the database or the network. Both branches of a condition
+// are planned.
+func (e *Executor) Plan(dag *DAG, input map[string]interface{}) (*Plan, error) {
+	if errs := Validate(dag); len(errs) > 0 {
+		return nil, ValidationErrors(errs)
+	}
+	if err := validateSchema(dag.InputSchema, input); err != nil {
+		return nil, fmt.Errorf("input validation failed: %w", err)
+	}
+	stepsMap, err := e.mapSteps(dag)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := buildGraph(dag, stepsMap)
+	if err != nil {
+		return nil, err
+	}
+
+	waves, wave := graph.waves()
+	planner := &planner{
+		state: &Context{Input: &input, Results: &map[string]interface{}{}, Errors: &map[string]interface{}{}},
+	}
+	if e.db != nil {
+		planner.queries, _ = (*e.db).(QueryPlanner)
+	}
+
+	plan := &Plan{Waves: waves, Steps: make([]StepPlan, 0, len(dag.Steps))}
+	for _, step := range dag.Steps {
+		stepPlan := planner.step(&step)
+		stepPlan.Wave = wave[step.ID]
+		stepPlan.DependsOn = graph.predecessors[step.ID]
+		plan.Steps = append(plan.Steps, stepPlan)
+	}
+	return plan, nil
+}
+
+// waves groups the steps by the length of the longest path leading to them,
+// so every step comes after all of its predecessors
+func (g *graph) waves() ([][]string, map[string]int) {
+	wave := make(map[string]int, len(g.order))
+	indegree := g.indegrees()
+	queue := g.roots()
+	for _, id := range queue {
+		wave[id] = 0
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range g.successors[id] {
+			if wave[id]+1 > wave[next] {
+				wave[next] = wave[id] + 1
+			}
+			indegree[next]--
+			if indegree[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	waves := make([][]string, 0)
+	for id, n := range wave {
+		for len(waves) <= n {
+			waves = append(waves, make([]string, 0))
+		}
+		waves[n] = append(waves[n], id)
+	}
+	for _, ids := range waves {
+		g.sort(ids)
+	}
+	return waves, wave
+}
+
+var errRunState = errors.New("expression reads run-time state")
+
+// planner resolves step params against the input alone
+type planner struct {
+	state      *Context
+	queries    QueryPlanner
+	unresolved []string
+}
+
+func (p *planner) step(step *Step) StepPlan {
+	p.unresolved = nil
+	plan := StepPlan{ID: step.ID, Name: step.Name, Type: step.Type}
+
+	var query string
+	var args []interface{}
+	var err error
+	switch step.Type {
+	case Query, Insert, Update, Delete:
+		if p.queries == nil {
+			plan.Error = "database does not support planning"
+			return plan
+		}
+		where := p.values(step.Where)
+		switch step.Type {
+		case Query:
+			var cursor interface{}
+			if step.After != "" {
+				unresolved := len(p.unresolved)
+				cursor = p.resolve(step.After)
+				if len(p.unresolved) > unresolved {
+					// the cursor comes from another step, plan the first page
+					cursor = nil
+				}
+			}
+			var page *Page
+			page, err = queryPage(step, cursor)
+			if err == nil {
+				query, args, err = p.queries.PlanRetrieve(step.Table, step.Select, where, page)
+			}
+		case Insert:
+			query, args, err = p.queries.PlanCreate(step.Table, p.values(step.Params.Map), step.Returning)
+		case Update:
+			query, args, err = p.queries.PlanUpdate(step.Table, p.values(step.Set), where, mutation(step))
+		case Delete:
+			query, args, err = p.queries.PlanDelete(step.Table, where, mutation(step))
+		}
+		if err != nil {
+			plan.Error = err.Error()
+		} else {
+			plan.SQL = &SQLPlan{Query: query, Args: args}
+		}
+	case HTTP:
+		plan.HTTP = &HTTPPlan{
+			Method:  step.Method,
+			URL:     p.string(step.URL),
+			Query:   p.values(step.Query),
+			Body:    p.values(step.Body),
+			Headers: step.Headers,
+		}
+	}
+	plan.Unresolved = p.unresolved
+	return plan
+}
+
+// values resolves a params map the way resolveValues does, leaving strings
+// that read run-time state untouched
+func (p *planner) values(params map[string]interface{}) map[string]interface{} {
+	resolved := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		resolved[key] = p.value(value)
+	}
+	return resolved
+}
+
+func (p *planner) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return p.resolve(v)
+	case map[string]interface{}:
+		return p.values(v)
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved[i] = p.value(item)
+		}
+		return resolved
+	default:
+		return v
+	}
+}
+
+func (p *planner) string(str string) string {
+	return fmt.Sprint(p.resolve(str))
+}
+
+// resolve evaluates str like resolveV2 unless one of its expressions reads
+// run-time state or cannot be evaluated against the input
+func (p *planner) resolve(str string) interface{} {
+	env := map[string]interface{}{"input": p.state.Input}
+	var value interface{}
+	sources := expressions(str)
+	for _, src := range sources {
+		tree, err := parser.Parse(src)
+		if err == nil && readsRunState(tree.Node) {
+			err = errRunState
+		}
+		if err == nil {
+			value, err = expr.Eval(src, env)
+		}
+		if err != nil {
+			p.unresolved = append(p.unresolved, str)
+			return str
+		}
+	}
+	if len(sources) == 1 && !strings.Contains(str, "${") {
+		return value
+	}
+	return resolveV2[interface{}](str, p.state)
+}
+
+// runStateVisitor reports whether an expression reads results or errors
+type runStateVisitor struct {
+	found bool
+}
+
+func (r *runStateVisitor) Visit(node *ast.Node) {
+	if ident, ok := (*node).(*ast.IdentifierNode); ok && (ident.Value == "results" || ident.Value == "errors") {
+		r.found = true
+	}
+}
+
+func readsRunState(node ast.Node) bool {
+	visitor := &runStateVisitor{}
+	ast.Walk(&node, visitor)
+	return visitor.found
+}
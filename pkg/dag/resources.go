@@ -0,0 +1,126 @@
+package dag
+
+import (
+	"context"
+	"net/url"
+	"sort"
+)
+
+// DatabaseResource is the resource pool every query, insert, update and delete
+// step acquires. HTTP steps acquire http:<host> for the host they call.
+const DatabaseResource = "db"
+
+// WithMaxParallelSteps bounds how many steps run at once across all runs of
+// the executor. The steps of the sub-graph of a foreach, dag or transaction
+// step run within the slot of that step.
+func WithMaxParallelSteps(n int) ExecutorOption {
+	return func(e *Executor) {
+		if n > 0 {
+			e.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithMaxParallelStepsPerRun bounds how many steps of a single run are
+// dispatched at once when its DAG does not set maxParallel
+func WithMaxParallelStepsPerRun(n int) ExecutorOption {
+	return func(e *Executor) {
+		e.maxParallel = n
+	}
+}
+
+// WithResourcePool lets at most size steps holding the named resource run at
+// once across all runs of the executor, e.g. DatabaseResource to stay below
+// the connection pool or "http:api.partner.com" to spare a downstream API.
+// Resources without a pool are not limited, and neither are pools of size 0
+// or less.
+func WithResourcePool(name string, size int) ExecutorOption {
+	return func(e *Executor) {
+		if size < 1 {
+			return
+		}
+		if e.pools == nil {
+			e.pools = make(map[string]chan struct{})
+		}
+		e.pools[name] = make(chan struct{}, size)
+	}
+}
+
+// maxParallel returns how many steps of the execution may be dispatched at
+// once, 0 for no limit
+func (e *Execution) maxParallel() int {
+	if e.dag.MaxParallel > 0 {
+		return e.dag.MaxParallel
+	}
+	return e.executor.maxParallel
+}
+
+// resources returns the resources a step holds while it runs: the ones it
+// names, the database for database steps and the host of HTTP steps
+func resources(step *Step, state *Context) []string {
+	names := append([]string(nil), step.Resources...)
+	switch step.Type {
+	case Query, Insert, Update, Delete:
+		names = append(names, DatabaseResource)
+	case HTTP:
+		if u, err := url.Parse(resolveV2[string](step.URL, state)); err == nil && u.Host != "" {
+			names = append(names, "http:"+u.Host)
+		}
+	}
+	return names
+}
+
+// holding returns the resources held while the sub-graph of step runs: the
+// ones the steps running e hold and the ones step names
+func (e *Execution) holding(step *Step) map[string]bool {
+	held := make(map[string]bool, len(e.held)+len(step.Resources))
+	for name := range e.held {
+		held[name] = true
+	}
+	for _, name := range step.Resources {
+		held[name] = true
+	}
+	return held
+}
+
+// acquire waits for a slot of the executor and of every resource pool the
+// step uses. Pools are always taken in the same order so steps waiting for
+// each other cannot deadlock, and the steps of a sub-graph run within the
+// slots of the step running it instead of waiting for them again. The
+// returned function gives the slots back.
+func (e *Execution) acquire(step *Step, state *Context) (func(), error) {
+	held := make([]chan struct{}, 0)
+	release := func() {
+		for _, slot := range held {
+			<-slot
+		}
+	}
+	take := func(slot chan struct{}) error {
+		select {
+		case slot <- struct{}{}:
+			held = append(held, slot)
+			return nil
+		case <-e.ctx.Done():
+			release()
+			return context.Cause(e.ctx)
+		}
+	}
+
+	if e.executor.slots != nil && !e.nested {
+		if err := take(e.executor.slots); err != nil {
+			return nil, err
+		}
+	}
+	names := resources(step, state)
+	sort.Strings(names)
+	for i, name := range names {
+		pool, ok := e.executor.pools[name]
+		if !ok || e.held[name] || (i > 0 && names[i-1] == name) {
+			continue
+		}
+		if err := take(pool); err != nil {
+			return nil, err
+		}
+	}
+	return release, nil
+}
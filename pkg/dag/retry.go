@@ -0,0 +1,148 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryInitialDelay = 100 * time.Millisecond
+	defaultRetryMultiplier   = 2.0
+)
+
+// StatusError is returned for HTTP responses whose status code a retry policy
+// treats as a failure
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %d", e.StatusCode)
+}
+
+// attempts returns how many times a step may run in total
+func (p *RetryPolicy) attempts() int {
+	if p == nil || p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// delay returns how long to wait before the attempt following attempt n
+func (p *RetryPolicy) delay(n int) time.Duration {
+	initial := parseDuration(p.InitialDelay, defaultRetryInitialDelay)
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+	delay := float64(initial) * math.Pow(multiplier, float64(n-1))
+	if maxDelay := parseDuration(p.MaxDelay, 0); maxDelay > 0 && delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if p.Jitter > 0 {
+		// spread the delay over [delay*(1-jitter), delay*(1+jitter)]
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// statusError turns an HTTP result with a retryable status code into an error
+func (p *RetryPolicy) statusError(result interface{}) error {
+	response, ok := result.(*ParsedResponse)
+	if p == nil || !ok || response == nil {
+		return nil
+	}
+	for _, code := range p.RetryOnStatus {
+		if response.StatusCode == code {
+			return &StatusError{StatusCode: code}
+		}
+	}
+	return nil
+}
+
+// retryable reports whether err is worth another attempt. Cancellation and
+// denied access never are; otherwise every error is retried unless RetryOn
+// narrows it down.
+func (p *RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrAccessDenied) {
+		return false
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) || len(p.RetryOn) == 0 {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, pattern := range p.RetryOn {
+		if strings.Contains(message, strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// executeWithRetry runs a step, retrying it according to its retry policy and
+// recording every attempt in the trace
+func (e *Execution) executeWithRetry(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	policy := step.Retry
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		result, err := e.runAttempt(ctx, step, state)
+		if err == nil {
+			err = policy.statusError(result)
+		}
+
+		record := Attempt{Number: attempt, StartedAt: started, FinishedAt: time.Now()}
+		if response, ok := result.(*ParsedResponse); ok && response != nil {
+			record.StatusCode = response.StatusCode
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		e.trace.addAttempt(step.ID, record)
+
+		if err == nil {
+			return result, nil
+		}
+		if attempt >= policy.attempts() || ctx.Err() != nil || !policy.retryable(err) {
+			if attempt > 1 {
+				return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+
+		wait := policy.delay(attempt)
+		select {
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runAttempt executes a step once. It waits for the step to return even when
+// ctx is done, so a timed-out write is never retried or reported while it may
+// still commit; an error after ctx is done is reported as its cause.
+func (e *Execution) runAttempt(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	result, err := e.executeStep(ctx, step, state)
+	if err != nil && ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return result, err
+}
+
+// parseDuration parses a duration string, falling back when it is empty or invalid
+func parseDuration(value string, fallback time.Duration) time.Duration {
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
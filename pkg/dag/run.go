@@ -0,0 +1,224 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type RunStatus string
+
+const (
+	RunRunning   RunStatus = "running"
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunCancelled RunStatus = "cancelled"
+	// a failed run whose completed steps were undone by their compensate steps
+	RunCompensated        RunStatus = "compensated"
+	RunCompensationFailed RunStatus = "compensation_failed"
+)
+
+// ErrRunNotFound is returned by a RunStore for unknown run IDs
+var ErrRunNotFound = errors.New("run not found")
+
+// Run is the record of a single asynchronous execution of a DAG
+type Run struct {
+	ID          string                 `json:"id" bson:"id"`
+	DAGID       string                 `json:"dagId,omitempty" bson:"dagId,omitempty"`
+	Status      RunStatus              `json:"status" bson:"status"`
+	DAG         *DAG                   `json:"dag,omitempty" bson:"dag,omitempty"` // definition the run executes, kept for resuming
+	Input       map[string]interface{} `json:"input,omitempty" bson:"input,omitempty"`
+	Output      interface{}            `json:"output,omitempty" bson:"output"`
+	Error       string                 `json:"error,omitempty" bson:"error"`
+	ErrorKind   ErrorKind              `json:"errorKind,omitempty" bson:"errorKind"`
+	CreatedAt   time.Time              `json:"createdAt" bson:"createdAt"`
+	FinishedAt  *time.Time             `json:"finishedAt,omitempty" bson:"finishedAt"`
+	CancelledBy string                 `json:"cancelledBy,omitempty" bson:"cancelledBy"`
+	CancelledAt *time.Time             `json:"cancelledAt,omitempty" bson:"cancelledAt"`
+	ResumedAt   *time.Time             `json:"resumedAt,omitempty" bson:"resumedAt"`
+	Resumes     int                    `json:"resumes,omitempty" bson:"resumes,omitempty"`     // times the run was resumed
+	Results     map[string]interface{} `json:"results,omitempty" bson:"results,omitempty"`     // checkpointed step results
+	RerunOf     string                 `json:"rerunOf,omitempty" bson:"rerunOf,omitempty"`     // run whose results a partial re-run reuses
+	RerunFrom   []string               `json:"rerunFrom,omitempty" bson:"rerunFrom,omitempty"` // steps a partial re-run starts from
+	Trace       *Trace                 `json:"trace,omitempty" bson:"trace,omitempty"`
+}
+
+// RunFilter narrows down the runs returned by RunStore.ListRuns. Zero fields
+// do not filter.
+type RunFilter struct {
+	DAGID         string
+	Status        RunStatus
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+}
+
+// Checkpointer durably records the outcome of every step of a run as it
+// completes, so the run can be resumed after the process dies
+type Checkpointer interface {
+	Checkpoint(ctx context.Context, runID string, step StepTrace, result interface{}) error
+}
+
+// RunStore persists run records and their step checkpoints
+type RunStore interface {
+	Checkpointer
+	CreateRun(ctx context.Context, run *Run) error
+	UpdateRun(ctx context.Context, run *Run) error
+	// ClaimRun updates a run like UpdateRun, but only while the stored run
+	// still has the given status and number of resumes, and reports whether
+	// it did. It keeps two processes from resuming the same run.
+	ClaimRun(ctx context.Context, run *Run, status RunStatus, resumes int) (bool, error)
+	GetRun(ctx context.Context, id string) (*Run, error)
+	ListRuns(ctx context.Context, filter RunFilter) ([]Run, error)
+}
+
+// Finish records the outcome of an execution on the run
+func (r *Run) Finish(result *Result, err error) {
+	now := time.Now()
+	r.FinishedAt = &now
+	if result != nil && result.Trace != nil {
+		r.Trace = result.Trace.Copy()
+	}
+	r.Status = runStatus(err)
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorKind = ErrorKindOf(err)
+		return
+	}
+	r.Output = result.Output
+}
+
+// SucceededResults returns the checkpointed results of the steps that
+// succeeded, which a resumed execution does not run again
+func (r *Run) SucceededResults() map[string]interface{} {
+	results := make(map[string]interface{})
+	if r.Trace == nil {
+		return results
+	}
+	for id, step := range r.Trace.Steps {
+		if step.Status == StepSucceeded {
+			results[id] = r.Results[id]
+		}
+	}
+	return results
+}
+
+// runStatus returns the status of a run that ended with err
+func runStatus(err error) RunStatus {
+	var compensationErr *CompensationError
+	switch {
+	case err == nil:
+		return RunSucceeded
+	case errors.As(err, &compensationErr) && len(compensationErr.Failures) > 0:
+		return RunCompensationFailed
+	case errors.As(err, &compensationErr):
+		return RunCompensated
+	case ErrorKindOf(err) == ErrorCancelled:
+		return RunCancelled
+	default:
+		return RunFailed
+	}
+}
+
+type runConfig struct {
+	runID        string
+	trace        *Trace
+	restored     map[string]interface{}
+	rerunFrom    []string
+	only         map[string]bool
+	checkpointer Checkpointer
+}
+
+// RunOption configures a single execution
+type RunOption func(*runConfig)
+
+// WithTrace records the execution into trace instead of a fresh one, so the
+// caller can watch the steps progress while the DAG runs
+func WithTrace(trace *Trace) RunOption {
+	return func(c *runConfig) {
+		c.trace = trace
+	}
+}
+
+// WithResults resumes an execution: steps with a result here count as
+// succeeded and are not executed again
+func WithResults(results map[string]interface{}) RunOption {
+	return func(c *runConfig) {
+		c.restored = results
+	}
+}
+
+// WithCheckpointer records the outcome of every step with checkpointer before
+// the steps depending on it start. WithRunID names the run to checkpoint.
+func WithCheckpointer(checkpointer Checkpointer) RunOption {
+	return func(c *runConfig) {
+		c.checkpointer = checkpointer
+	}
+}
+
+// WithRerunFrom re-runs only the given steps and their descendants. Combined
+// with WithResults holding the results of a previous run, every other step
+// reuses its recorded result instead of executing again.
+func WithRerunFrom(stepIDs ...string) RunOption {
+	return func(c *runConfig) {
+		c.rerunFrom = stepIDs
+	}
+}
+
+// rerun narrows the execution down to the steps to re-run and their
+// descendants, dropping the recorded results those steps will replace
+func (c *runConfig) rerun(g *graph) error {
+	for _, id := range c.rerunFrom {
+		if _, ok := g.order[id]; !ok {
+			return fmt.Errorf("cannot re-run unknown step %s", id)
+		}
+	}
+	c.only = g.descendants(c.rerunFrom)
+
+	restored := make(map[string]interface{}, len(c.restored))
+	for id, result := range c.restored {
+		if !c.only[id] {
+			restored[id] = result
+		}
+	}
+	c.restored = restored
+
+	// a re-run step is only released once every one of its predecessors has
+	// either been re-run or restored
+	ids := sortedKeys(c.only)
+	sort.Slice(ids, func(i, j int) bool { return g.order[ids[i]] < g.order[ids[j]] })
+	for _, id := range ids {
+		for _, predecessor := range g.predecessors[id] {
+			if _, ok := restored[predecessor]; !ok && !c.only[predecessor] {
+				return fmt.Errorf("cannot re-run step %s: step %s has no recorded result", id, predecessor)
+			}
+		}
+	}
+	return nil
+}
+
+// AffectedSteps returns the steps a re-run from the given steps executes: the
+// steps themselves and all of their descendants, in definition order
+func AffectedSteps(dag *DAG, from []string) ([]string, error) {
+	steps := make(map[string]*Step, len(dag.Steps))
+	for i := range dag.Steps {
+		steps[dag.Steps[i].ID] = &dag.Steps[i]
+	}
+	g, err := buildGraph(dag, steps)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range from {
+		if _, ok := steps[id]; !ok {
+			return nil, fmt.Errorf("cannot re-run unknown step %s", id)
+		}
+	}
+
+	affected := make([]string, 0)
+	for id := range g.descendants(from) {
+		affected = append(affected, id)
+	}
+	g.sort(affected)
+	return affected, nil
+}
@@ -0,0 +1,91 @@
+package dag
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRerunFrom(t *testing.T) {
+	d := &DAG{ID: "rerun", InputSchema: Schema{Type: "object"}, Steps: []Step{
+		query("a", "a"),
+		query("b", "b"),
+		query("c", "c", "a", "b"),
+		query("d", "d", "c"),
+		{ID: "output", Type: Output, DependsOn: []string{"d"}, Params: Params{OutputParams: OutputParams{Source: "d", Schema: Schema{Type: "array"}}}},
+	}}
+	row := func(table string) []interface{} {
+		return []interface{}{map[string]interface{}{"table": table}}
+	}
+
+	tests := []struct {
+		name    string
+		results map[string]interface{}
+		from    []string
+		queries int64
+		err     string
+	}{
+		{name: "restored predecessors", results: map[string]interface{}{"a": row("a"), "b": row("b")}, from: []string{"a"}, queries: 3},
+		{name: "re-run predecessors", results: map[string]interface{}{}, from: []string{"a", "b"}, queries: 4},
+		{name: "missing predecessor of a descendant", results: map[string]interface{}{"a": row("a")}, from: []string{"a"}, err: "cannot re-run step c: step b has no recorded result"},
+		{name: "unknown step", results: map[string]interface{}{}, from: []string{"x"}, err: "cannot re-run unknown step x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			executor, err := NewExecutor(db, &fakeHTTP{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = executor.ExecuteWithTrace(context.Background(), d, map[string]interface{}{}, WithResults(tt.results), WithRerunFrom(tt.from...))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+				if db.queries != 0 {
+					t.Errorf("ran %d queries before failing", db.queries)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("execute: %v", err)
+			}
+			if db.queries != tt.queries {
+				t.Errorf("got %d queries, want %d", db.queries, tt.queries)
+			}
+		})
+	}
+}
+
+func TestRunEncodesHTTPResults(t *testing.T) {
+	// the raw response holds a request that cannot be encoded
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(`{"id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &ParsedResponse{
+		Data:       map[string]interface{}{"id": 1},
+		Raw:        &http.Response{StatusCode: http.StatusOK, Request: req},
+		StatusCode: http.StatusOK,
+	}
+	run := &Run{ID: "run-1", Status: RunSucceeded, Output: response, Results: map[string]interface{}{"post": response}}
+
+	data, err := json.Marshal(run)
+	if err != nil {
+		t.Fatalf("marshal run to JSON: %v", err)
+	}
+	var decoded Run
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal run: %v", err)
+	}
+	if result, ok := decoded.Results["post"].(map[string]interface{}); !ok || result["StatusCode"] != float64(http.StatusOK) {
+		t.Errorf("got result %v, want the parsed response", decoded.Results["post"])
+	}
+	if _, err := bson.Marshal(run); err != nil {
+		t.Errorf("marshal run to BSON: %v", err)
+	}
+}
@@ -0,0 +1,543 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	utils "github.com/lynnphayu/dag-runner/pkg/utils"
+)
+
+// graph holds the deduplicated edges between the steps of a DAG
+type graph struct {
+	order        map[string]int // position of each step in the DAG definition
+	successors   map[string][]string
+	predecessors map[string][]string
+}
+
+// buildGraph derives the scheduling edges from Then, Else, switch cases, DependsOn and onError.goto
+func buildGraph(dag *DAG, steps map[string]*Step) (*graph, error) {
+	g := &graph{
+		order:        make(map[string]int, len(dag.Steps)),
+		successors:   make(map[string][]string, len(dag.Steps)),
+		predecessors: make(map[string][]string, len(dag.Steps)),
+	}
+	for i, step := range dag.Steps {
+		g.order[step.ID] = i
+	}
+
+	seen := make(map[[2]string]bool)
+	addEdge := func(from, to string) error {
+		if _, ok := steps[from]; !ok {
+			return fmt.Errorf("step %s references unknown step %s", to, from)
+		}
+		if _, ok := steps[to]; !ok {
+			return fmt.Errorf("step %s references unknown step %s", from, to)
+		}
+		if seen[[2]string{from, to}] {
+			return nil
+		}
+		seen[[2]string{from, to}] = true
+		g.successors[from] = append(g.successors[from], to)
+		g.predecessors[to] = append(g.predecessors[to], from)
+		return nil
+	}
+
+	for _, step := range dag.Steps {
+		for _, next := range step.Then {
+			if err := addEdge(step.ID, next); err != nil {
+				return nil, err
+			}
+		}
+		// only for Condition type
+		for _, next := range step.Else {
+			if err := addEdge(step.ID, next); err != nil {
+				return nil, err
+			}
+		}
+		// only for Switch type
+		for _, c := range step.Cases {
+			for _, next := range c.Then {
+				if err := addEdge(step.ID, next); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, next := range step.Default {
+			if err := addEdge(step.ID, next); err != nil {
+				return nil, err
+			}
+		}
+		for _, dep := range step.DependsOn {
+			if err := addEdge(dep, step.ID); err != nil {
+				return nil, err
+			}
+		}
+		if step.OnError != nil {
+			for _, next := range step.OnError.Goto {
+				if err := addEdge(step.ID, next); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// indegrees returns the number of incoming edges of every step
+func (g *graph) indegrees() map[string]int {
+	degrees := make(map[string]int, len(g.order))
+	for id := range g.order {
+		degrees[id] = len(g.predecessors[id])
+	}
+	return degrees
+}
+
+// roots returns the steps without incoming edges in definition order
+func (g *graph) roots() []string {
+	roots := make([]string, 0)
+	for id := range g.order {
+		if len(g.predecessors[id]) == 0 {
+			roots = append(roots, id)
+		}
+	}
+	g.sort(roots)
+	return roots
+}
+
+// descendants returns the given steps together with every step reachable from them
+func (g *graph) descendants(ids []string) map[string]bool {
+	seen := make(map[string]bool, len(ids))
+	queue := append([]string(nil), ids...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		queue = append(queue, g.successors[id]...)
+	}
+	return seen
+}
+
+// sort orders step IDs by their position in the DAG definition
+func (g *graph) sort(ids []string) {
+	sort.Slice(ids, func(i, j int) bool {
+		return g.order[ids[i]] < g.order[ids[j]]
+	})
+}
+
+// results is the concurrency-safe store of step results for one execution
+type results struct {
+	mu     sync.RWMutex
+	values map[string]interface{}
+}
+
+func newResults() *results {
+	return &results{values: make(map[string]interface{})}
+}
+
+func (r *results) store(stepID string, value interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[stepID] = value
+}
+
+func (r *results) load(stepID string) (interface{}, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.values[stepID]
+	return v, ok
+}
+
+// snapshot copies the current results so a step can read them without locking
+func (r *results) snapshot() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snapshot := make(map[string]interface{}, len(r.values))
+	for k, v := range r.values {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// completion is reported by a step goroutine back to the scheduler
+type completion struct {
+	StepID   string
+	Result   interface{}
+	Err      error
+	Restored bool // completed in an earlier execution of the run
+}
+
+// run dispatches steps as soon as all of their dependencies have completed
+// and their trigger rule is met.
+// Only the calling goroutine touches the scheduling state; steps report back
+// through a channel so no completion event can be consumed by the wrong waiter.
+// Steps restored from a checkpoint complete with their recorded result instead
+// of being dispatched. Ready steps beyond the run's parallelism limit wait for
+// a running step to complete. Every unhandled step error is collected and
+// returned together.
+func (e *Execution) run() error {
+	gates := newGates(e.graph)
+	ready := e.graph.roots()
+	readyAt := make(map[string]time.Time, len(e.graph.order))
+	done := make(chan completion)
+	restored := make([]completion, 0)
+	running := 0
+	limit := e.maxParallel()
+	failures := make([]error, 0)
+	for _, id := range ready {
+		readyAt[id] = time.Now()
+		e.emit(Event{Type: EventStepQueued, StepID: id})
+	}
+
+	for {
+		waiting := make([]string, 0)
+		if len(failures) == 0 && e.ctx.Err() == nil {
+			for _, id := range ready {
+				if result, ok := e.restored[id]; ok {
+					restored = append(restored, completion{StepID: id, Result: result, Restored: true})
+					continue
+				}
+				if e.only != nil && !e.only[id] {
+					// outside a partial re-run and without a recorded result
+					continue
+				}
+				if limit > 0 && running >= limit {
+					waiting = append(waiting, id)
+					continue
+				}
+				running++
+				e.trace.startStep(id)
+				e.emit(Event{Type: EventStepStarted, StepID: id})
+				go e.dispatch(e.stepsMap[id], readyAt[id], done)
+			}
+		}
+		ready = waiting
+
+		var c completion
+		if len(restored) > 0 {
+			c, restored = restored[0], restored[1:]
+		} else if running > 0 {
+			c = <-done
+			running--
+		} else {
+			break
+		}
+		step := e.stepsMap[c.StepID]
+
+		var released []string
+		if c.Err != nil {
+			next, handled := e.handleError(step, c.Err)
+			if err := e.checkpoint(step.ID); err != nil {
+				failures = append(failures, err)
+				continue
+			}
+			if !handled {
+				failures = append(failures, c.Err)
+				continue
+			}
+			released = next
+		} else {
+			e.results.store(step.ID, c.Result)
+			if !c.Restored {
+				e.trace.finishStep(step.ID, nil)
+				if err := e.checkpoint(step.ID); err != nil {
+					failures = append(failures, err)
+					continue
+				}
+			}
+			e.emit(Event{Type: EventStepSucceeded, StepID: step.ID})
+			released = e.activated(step, c.Result)
+		}
+
+		next := e.settle(gates, step.ID, released, c.Err != nil)
+		e.graph.sort(next)
+		for _, id := range next {
+			readyAt[id] = time.Now()
+			e.emit(Event{Type: EventStepQueued, StepID: id})
+		}
+		ready = append(ready, next...)
+	}
+
+	if len(failures) > 0 {
+		return errors.Join(failures...)
+	}
+	if e.ctx.Err() != nil {
+		return fmt.Errorf("execution aborted: %w", context.Cause(e.ctx))
+	}
+	e.skipPending()
+	return nil
+}
+
+// checkpoint hands the outcome of a step to the run's checkpointer before its
+// successors are released. It outlives a cancelled run so the outcome of
+// every finished step is recorded.
+func (e *Execution) checkpoint(stepID string) error {
+	if e.checkpointer == nil {
+		return nil
+	}
+	trace, _ := e.trace.Step(stepID)
+	result, _ := e.results.load(stepID)
+	if err := e.checkpointer.Checkpoint(context.WithoutCancel(e.ctx), e.runID, trace, result); err != nil {
+		return fmt.Errorf("failed to checkpoint step %s: %w", stepID, err)
+	}
+	return nil
+}
+
+// skipPending marks the steps a finished run never released, such as the
+// steps outside a partial re-run, as skipped
+func (e *Execution) skipPending() {
+	for _, step := range e.dag.Steps {
+		if trace, ok := e.trace.Step(step.ID); ok && trace.Status == StepPending {
+			e.skip(step.ID, "not released")
+		}
+	}
+}
+
+func (e *Execution) skip(stepID string, reason string) {
+	e.trace.skipStep(stepID, reason)
+	e.emit(Event{Type: EventStepSkipped, StepID: stepID})
+}
+
+// edgeOutcome is what a finished or skipped step passes on to a successor
+type edgeOutcome int
+
+const (
+	edgeSucceeded edgeOutcome = iota
+	edgeSkipped
+	edgeFailed
+)
+
+// gates collects the outcomes of the incoming edges of every step until all
+// of its predecessors are settled
+type gates struct {
+	remaining map[string]int
+	outcomes  map[string]map[edgeOutcome]int
+}
+
+func newGates(g *graph) *gates {
+	return &gates{
+		remaining: g.indegrees(),
+		outcomes:  make(map[string]map[edgeOutcome]int, len(g.order)),
+	}
+}
+
+// settle passes the outcome of a step on to its successors and returns the
+// ones that may now run. Successors released by the step count as succeeded
+// edges, the others as failed when the step failed and skipped otherwise.
+// Successors whose trigger rule is not met are skipped, settling their own
+// successors in turn.
+func (e *Execution) settle(gates *gates, stepID string, released []string, failed bool) []string {
+	releasedSet := make(map[string]bool, len(released))
+	for _, id := range released {
+		releasedSet[id] = true
+	}
+
+	ready := make([]string, 0)
+	for _, id := range e.graph.successors[stepID] {
+		outcome := edgeSkipped
+		if releasedSet[id] {
+			outcome = edgeSucceeded
+		} else if failed {
+			outcome = edgeFailed
+		}
+		if gates.outcomes[id] == nil {
+			gates.outcomes[id] = make(map[edgeOutcome]int)
+		}
+		gates.outcomes[id][outcome]++
+		gates.remaining[id]--
+		if gates.remaining[id] > 0 {
+			continue
+		}
+
+		outcomes := gates.outcomes[id]
+		rule := e.stepsMap[id].TriggerRule
+		if triggered(rule, outcomes) {
+			ready = append(ready, id)
+			continue
+		}
+		if outcomes[edgeSucceeded]+outcomes[edgeFailed] == 0 {
+			e.skip(id, "branch not taken")
+		} else {
+			if rule == "" {
+				rule = TriggerAllSuccess
+			}
+			e.skip(id, fmt.Sprintf("trigger rule %s not met", rule))
+		}
+		ready = append(ready, e.settle(gates, id, nil, false)...)
+	}
+	return ready
+}
+
+// triggered reports whether a step with the given rule runs after its
+// predecessors settled with the given outcomes
+func triggered(rule TriggerRule, outcomes map[edgeOutcome]int) bool {
+	succeeded, skipped, failed := outcomes[edgeSucceeded], outcomes[edgeSkipped], outcomes[edgeFailed]
+	if succeeded+failed == 0 {
+		// every predecessor was skipped
+		return false
+	}
+	switch rule {
+	case TriggerOneSuccess:
+		return succeeded > 0
+	case TriggerAllDone:
+		return true
+	case TriggerNoneFailed:
+		return failed == 0
+	default:
+		return skipped == 0 && failed == 0
+	}
+}
+
+// handleError applies the step's error policy, returning the steps to release
+// and whether the run may carry on
+func (e *Execution) handleError(step *Step, err error) ([]string, bool) {
+	e.trace.finishStep(step.ID, err)
+	info := errorInfo(err)
+	e.errors.store(step.ID, info)
+	e.emit(Event{Type: EventStepFailed, StepID: step.ID, Error: info["message"].(string), ErrorKind: ErrorKindOf(err)})
+
+	// a cancelled run stops regardless of the policy
+	policy := step.OnError
+	if ErrorKindOf(err) == ErrorCancelled || policy == nil || policy.Action == "" || policy.Action == OnErrorFail {
+		return nil, false
+	}
+	e.trace.handleStep(step.ID, policy.Action)
+
+	switch policy.Action {
+	case OnErrorContinue:
+		return e.activated(step, nil), true
+	case OnErrorFallback:
+		result := e.fallback(step)
+		e.results.store(step.ID, result)
+		return e.activated(step, result), true
+	case OnErrorGoto:
+		return policy.Goto, true
+	default:
+		return nil, false
+	}
+}
+
+// fallback resolves the fallback value of a step against the current results
+func (e *Execution) fallback(step *Step) interface{} {
+	if str, ok := step.OnError.Fallback.(string); ok {
+		return resolveV2[interface{}](str, e.stepContext())
+	}
+	return resolveValues(step.OnError.Fallback, e.stepContext())
+}
+
+// errorInfo is what catch steps see under $errors.<stepId>
+func errorInfo(err error) map[string]interface{} {
+	message := err.Error()
+	var stepErr *StepError
+	if errors.As(err, &stepErr) {
+		message = stepErr.Err.Error()
+	}
+	return map[string]interface{}{
+		"message": message,
+		"kind":    string(ErrorKindOf(err)),
+	}
+}
+
+// dispatch executes a single step under its timeout once it holds its slots
+// and reports its completion
+func (e *Execution) dispatch(step *Step, readyAt time.Time, done chan<- completion) {
+	state := e.stepContext()
+	release, err := e.acquire(step, state)
+	if err != nil {
+		done <- completion{StepID: step.ID, Err: newStepError(e.ctx, e.ctx, step.ID, err)}
+		return
+	}
+	defer release()
+	e.trace.waitStep(step.ID, time.Since(readyAt))
+
+	ctx := e.ctx
+	if timeout := parseDuration(step.Timeout, e.executor.stepTimeout); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(e.ctx, timeout, ErrStepTimeout)
+		defer cancel()
+	}
+
+	result, err := e.executeWithRetry(ctx, step, state)
+	if err != nil {
+		done <- completion{StepID: step.ID, Err: newStepError(e.ctx, ctx, step.ID, err)}
+		return
+	}
+	done <- completion{StepID: step.ID, Result: result}
+}
+
+// activated returns the successors a completed step releases. A condition
+// releases its then branch when it evaluated to true and its else branch
+// otherwise, a switch only the branch of the matching case and catch steps
+// are only released when the step failed.
+func (e *Execution) activated(step *Step, result interface{}) []string {
+	skip := make(map[string]bool)
+	if step.OnError != nil {
+		for _, id := range step.OnError.Goto {
+			skip[id] = true
+		}
+	}
+	taken := step.Then
+	switch step.Type {
+	case Cond:
+		notTaken := step.Else
+		if passed, _ := result.(bool); !passed {
+			taken, notTaken = step.Else, step.Then
+		}
+		for _, id := range notTaken {
+			skip[id] = true
+		}
+	case Switch:
+		branch := switchBranch(step, result)
+		for _, c := range step.Cases {
+			for _, id := range c.Then {
+				skip[id] = true
+			}
+		}
+		for _, id := range step.Default {
+			skip[id] = true
+		}
+		taken = append(append([]string(nil), step.Then...), branch...)
+	}
+	for _, id := range taken {
+		delete(skip, id)
+	}
+	next := make([]string, 0, len(e.graph.successors[step.ID]))
+	for _, id := range e.graph.successors[step.ID] {
+		if !skip[id] {
+			next = append(next, id)
+		}
+	}
+	return next
+}
+
+// switchBranch returns the steps of the case a switch step matched, or its
+// default steps. Results restored from a checkpoint carry the case as float64.
+func switchBranch(step *Step, result interface{}) []string {
+	matched, _ := result.(map[string]interface{})
+	i := -1
+	if matched != nil && utils.IsNumeric(matched["case"]) {
+		i = int(utils.ToFloat64(matched["case"]))
+	}
+	if i < 0 || i >= len(step.Cases) {
+		return step.Default
+	}
+	return step.Cases[i].Then
+}
+
+// stepContext builds the expression context a step resolves its params against
+func (e *Execution) stepContext() *Context {
+	results := e.results.snapshot()
+	stepErrors := e.errors.snapshot()
+	return &Context{
+		Input:   &e.input,
+		Results: &results,
+		Errors:  &stepErrors,
+		Vars:    e.vars,
+	}
+}
@@ -0,0 +1,296 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDB answers every query with a single row naming the table. Queries of
+// tables starting with "fail" fail, queries of tables starting with "block"
+// wait until their step is cancelled and updates of tables starting with
+// "slow" take 100ms whether cancelled or not.
+type fakeDB struct {
+	queries int64
+	updates int64
+	started chan string
+}
+
+func (f *fakeDB) Create(ctx context.Context, table string, data map[string]interface{}, returning []string) (interface{}, error) {
+	return int64(1), nil
+}
+
+func (f *fakeDB) Retrieve(ctx context.Context, table string, select_ []string, where map[string]interface{}, page *Page) ([]interface{}, error) {
+	atomic.AddInt64(&f.queries, 1)
+	switch {
+	case strings.HasPrefix(table, "fail"):
+		return nil, fmt.Errorf("query of %s failed", table)
+	case strings.HasPrefix(table, "block"):
+		if f.started != nil {
+			f.started <- table
+		}
+		<-ctx.Done()
+		return nil, context.Cause(ctx)
+	}
+	return []interface{}{map[string]interface{}{"table": table}}, nil
+}
+
+func (f *fakeDB) Update(ctx context.Context, table string, data, where map[string]interface{}, mutation *Mutation) (interface{}, error) {
+	if strings.HasPrefix(table, "slow") {
+		time.Sleep(100 * time.Millisecond)
+	}
+	atomic.AddInt64(&f.updates, 1)
+	return int64(1), nil
+}
+
+func (f *fakeDB) Delete(ctx context.Context, table string, where map[string]interface{}, mutation *Mutation) (interface{}, error) {
+	return int64(1), nil
+}
+
+func (f *fakeDB) GetTableNames(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) GetColumns(ctx context.Context, table string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (f *fakeDB) Begin(ctx context.Context) (Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+// fakeHTTP records the bodies posted to it and echoes them back
+type fakeHTTP struct {
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func (f *fakeHTTP) Post(ctx context.Context, url string, query map[string]interface{}, body map[string]interface{}, headers map[string]string) (*ParsedResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bodies = append(f.bodies, body)
+	return &ParsedResponse{Data: body, StatusCode: 200}, nil
+}
+
+func (f *fakeHTTP) Get(ctx context.Context, url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error) {
+	return &ParsedResponse{StatusCode: 200}, nil
+}
+
+func (f *fakeHTTP) Put(ctx context.Context, url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error) {
+	return &ParsedResponse{Data: body, StatusCode: 200}, nil
+}
+
+func (f *fakeHTTP) Delete(ctx context.Context, url string, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error) {
+	return &ParsedResponse{StatusCode: 200}, nil
+}
+
+func (f *fakeHTTP) Patch(ctx context.Context, url string, body map[string]interface{}, query map[string]interface{}, headers map[string]string) (*ParsedResponse, error) {
+	return &ParsedResponse{Data: body, StatusCode: 200}, nil
+}
+
+// query returns a query step of table that depends on the given steps
+func query(id, table string, dependsOn ...string) Step {
+	return Step{ID: id, Name: id, Type: Query, DependsOn: dependsOn, Params: Params{DbOperationParams: DbOperationParams{Table: table}}}
+}
+
+// fanDAG returns a DAG whose root query fans out to width queries of the
+// given tables, which fan back in to a single POST and the output
+func fanDAG(width int, table func(i int) string) *DAG {
+	d := &DAG{ID: "fan", InputSchema: Schema{Type: "object"}}
+	d.Steps = append(d.Steps, query("root", "root"))
+	body := make(map[string]interface{}, width)
+	mids := make([]string, width)
+	for i := range mids {
+		mids[i] = fmt.Sprintf("q%d", i)
+		step := query(mids[i], table(i), "root")
+		step.Where = map[string]interface{}{"parent": "$results.root[0].table"}
+		d.Steps = append(d.Steps, step)
+		body[mids[i]] = fmt.Sprintf("$results.%s[0].table", mids[i])
+	}
+	d.Steps = append(d.Steps,
+		Step{ID: "sink", Name: "sink", Type: HTTP, DependsOn: mids, Params: Params{HTTPParams: HTTPParams{Method: POST, URL: "http://example.com", Body: body}}},
+		Step{ID: "output", Type: Output, DependsOn: []string{"sink"}, Params: Params{OutputParams: OutputParams{Source: "sink", Schema: Schema{Type: "object"}}}},
+	)
+	return d
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	const width = 300
+	db, http := &fakeDB{}, &fakeHTTP{}
+	executor, err := NewExecutor(db, http)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := executor.ExecuteWithTrace(context.Background(), fanDAG(width, func(i int) string { return fmt.Sprintf("t%d", i) }), map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if db.queries != width+1 {
+		t.Errorf("got %d queries, want %d", db.queries, width+1)
+	}
+	if len(http.bodies) != 1 {
+		t.Fatalf("got %d posts, want 1", len(http.bodies))
+	}
+	for i := 0; i < width; i++ {
+		id := fmt.Sprintf("q%d", i)
+		if got := http.bodies[0][id]; got != fmt.Sprintf("t%d", i) {
+			t.Errorf("posted %s = %v, want t%d", id, got, i)
+		}
+	}
+	for id, step := range result.Trace.Steps {
+		if step.Status != StepSucceeded {
+			t.Errorf("step %s %s, want succeeded", id, step.Status)
+		}
+	}
+}
+
+func TestFanOutFailures(t *testing.T) {
+	const width = 300
+	failing := map[int]bool{7: true, 150: true, 299: true}
+	db, http := &fakeDB{}, &fakeHTTP{}
+	executor, err := NewExecutor(db, http)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := executor.ExecuteWithTrace(context.Background(), fanDAG(width, func(i int) string {
+		if failing[i] {
+			return fmt.Sprintf("fail%d", i)
+		}
+		return fmt.Sprintf("t%d", i)
+	}), map[string]interface{}{})
+	if err == nil {
+		t.Fatal("execute succeeded, want the failures")
+	}
+	var stepErr *StepError
+	if !errors.As(err, &stepErr) || !strings.HasPrefix(result.Trace.Steps[stepErr.StepID].Error, "query of fail") {
+		t.Fatalf("got %v, want a failure of a failing step", err)
+	}
+	if len(http.bodies) != 0 {
+		t.Errorf("sink posted after failures")
+	}
+	for i := range failing {
+		id := fmt.Sprintf("q%d", i)
+		if step, _ := result.Trace.Step(id); step.Status != StepFailed {
+			t.Errorf("step %s %s, want failed", id, step.Status)
+		}
+	}
+	if step, _ := result.Trace.Step("sink"); step.Status == StepSucceeded || step.Status == StepRunning {
+		t.Errorf("sink %s after failures", step.Status)
+	}
+}
+
+func TestFanOutCancel(t *testing.T) {
+	const width = 300
+	db, http := &fakeDB{started: make(chan string, width)}, &fakeHTTP{}
+	executor, err := NewExecutor(db, http)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		for i := 0; i < width; i++ {
+			<-db.started
+		}
+		cancel(ErrRunCancelled)
+	}()
+
+	done := make(chan struct{})
+	var result *Result
+	go func() {
+		defer close(done)
+		result, err = executor.ExecuteWithTrace(ctx, fanDAG(width, func(i int) string { return fmt.Sprintf("block%d", i) }), map[string]interface{}{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not stop after being cancelled")
+	}
+
+	run := &Run{ID: "run"}
+	run.Finish(result, err)
+	if run.Status != RunCancelled {
+		t.Fatalf("run %s (%v), want cancelled", run.Status, err)
+	}
+	if len(http.bodies) != 0 {
+		t.Errorf("sink posted after cancellation")
+	}
+	for i := 0; i < width; i++ {
+		id := fmt.Sprintf("q%d", i)
+		if step, _ := result.Trace.Step(id); step.Status != StepCancelled {
+			t.Errorf("step %s %s, want cancelled", id, step.Status)
+		}
+	}
+}
+
+func TestStepTimeoutAwaitsWrite(t *testing.T) {
+	db := &fakeDB{}
+	executor, err := NewExecutor(db, &fakeHTTP{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := Step{ID: "write", Name: "write", Type: Update, Timeout: "10ms", Retry: &RetryPolicy{MaxAttempts: 3}, Params: Params{DbOperationParams: DbOperationParams{
+		Table: "slow",
+		Where: map[string]interface{}{"id": 1},
+	}}}
+	write.Set = map[string]interface{}{"status": "seen"}
+	d := &DAG{ID: "timeout", InputSchema: Schema{Type: "object"}, Steps: []Step{
+		write,
+		{ID: "output", Type: Output, DependsOn: []string{"write"}, Params: Params{OutputParams: OutputParams{Source: "write", Schema: Schema{Type: "number"}}}},
+	}}
+
+	result, err := executor.ExecuteWithTrace(context.Background(), d, map[string]interface{}{})
+	if updates := atomic.LoadInt64(&db.updates); updates != 1 {
+		t.Fatalf("got %d finished updates when the run returned, want 1", updates)
+	}
+	if err != nil {
+		t.Fatalf("execute: %v, want the write that finished", err)
+	}
+	if step, _ := result.Trace.Step("write"); len(step.Attempts) != 1 {
+		t.Errorf("got %d attempts, want 1", len(step.Attempts))
+	}
+}
+
+func TestNestedStepsShareHeldResources(t *testing.T) {
+	db := &fakeDB{}
+	executor, err := NewExecutor(db, &fakeHTTP{}, WithResourcePool(DatabaseResource, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	each := Step{ID: "each", Name: "each", Type: ForEach, DependsOn: []string{"users"}, Resources: []string{DatabaseResource}}
+	each.Items = "$results.users"
+	each.Steps = []Step{
+		query("orders", "orders"),
+		{ID: "result", Type: Output, DependsOn: []string{"orders"}, Params: Params{OutputParams: OutputParams{Source: "orders", Schema: Schema{Type: "array"}}}},
+	}
+	d := &DAG{ID: "nested", InputSchema: Schema{Type: "object"}, Steps: []Step{
+		query("users", "users"),
+		each,
+		{ID: "output", Type: Output, DependsOn: []string{"each"}, Params: Params{OutputParams: OutputParams{Source: "each", Schema: Schema{Type: "array"}}}},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := executor.ExecuteWithTrace(ctx, d, map[string]interface{}{}); err != nil {
+		t.Fatalf("execute: %v", err)
+	}
+	if db.queries != 2 {
+		t.Errorf("got %d queries, want 2", db.queries)
+	}
+}
+
+func TestResourcePoolSize(t *testing.T) {
+	for _, size := range []int{-1, 0} {
+		executor, err := NewExecutor(&fakeDB{}, &fakeHTTP{}, WithResourcePool(DatabaseResource, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := executor.pools[DatabaseResource]; ok {
+			t.Errorf("got a pool of size %d, want none", size)
+		}
+	}
+}
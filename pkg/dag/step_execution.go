@@ -1,107 +1,83 @@
 package dag
 
 import (
+	"context"
 	"fmt"
-	"sync"
 
 	utils "github.com/lynnphayu/dag-runner/pkg/utils"
 )
 
-type ErrEvt struct {
-	StepID string
-	Err    error
-}
-
 type Context struct {
 	Input   *map[string]interface{}
 	Results *map[string]interface{}
+	Errors  *map[string]interface{} // errors of failed steps handled by their onError policy
+	Vars    map[string]interface{}  // extra variables in scope, such as $item inside a foreach step
 }
 
 type Execution struct {
+	ctx      context.Context
+	runID    string
 	dag      *DAG
 	stepsMap map[string]*Step
-	context  *Context
-	output   interface{}
-
-	waitList          *sync.Map
-	executor          *Executor
-	wg                *sync.WaitGroup
-	errorChannel      chan ErrEvt
-	completionChannel chan string
-}
-
-// initExecution executes a single step asynchronously and triggers dependent steps
-func (e *Execution) initExecution(step *Step) {
-	defer e.wg.Done()
-
-	for _, dep := range step.DependsOn {
-		if _, ok := (*e.context.Results)[dep]; !ok {
-			// wait for dependent steps to complete from completion channel
-			for stepId := range e.completionChannel {
-				if dep == stepId {
-					break
-				}
-			}
-		}
-	}
+	graph    *graph
+	input    map[string]interface{}
+	results  *results
+	errors   *results
+	trace    *Trace
 
-	fmt.Println("Executing step:", step.ID)
-	result, err := e.executeStep(step)
-	fmt.Println("Step result:", step.ID, result)
+	restored     map[string]interface{}
+	only         map[string]bool // steps a partial re-run may execute
+	checkpointer Checkpointer
 
-	if err != nil {
-		e.errorChannel <- ErrEvt{
-			StepID: step.ID,
-			Err:    err,
-		}
-		return
-	}
+	db     Persist   // the executor's database, or the transaction of an enclosing transaction step
+	access []*Access // access rules of this DAG and of every DAG running it
 
-	// Store the result
-	(*e.context.Results)[step.ID] = result
-	e.completionChannel <- step.ID
-	// if step.Output != nil && step.Output != "" {
-	// 	e.output = resolveValues(step.Output, e.context)
-	// }
-
-	for _, dep := range step.Then {
-		if _, ok := e.waitList.Load(dep); !ok {
-			e.waitList.Store(dep, true)
-			e.wg.Add(1)
-			go e.initExecution(e.stepsMap[dep])
-		}
+	vars   map[string]interface{}
+	nested bool            // runs a sub-graph, whose steps do not report events
+	held   map[string]bool // resources held by the steps running this sub-graph
+	stack  []string        // IDs of the DAGs running this one as a child, outermost first
 
-	}
+	executor *Executor
 }
 
 // executeStep executes a single step
-func (e *Execution) executeStep(step *Step) (interface{}, error) {
+func (e *Execution) executeStep(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 	// Handle different step types
 	switch step.Type {
 	case Query:
-		return e.executeQuery(step)
+		return e.executeQuery(ctx, step, state)
 	case Insert:
-		return e.executeInsert(step)
+		return e.executeInsert(ctx, step, state)
 	case Update:
-		return e.executeUpdate(step)
+		return e.executeUpdate(ctx, step, state)
 	case Delete:
-		return e.executeDelete(step)
+		return e.executeDelete(ctx, step, state)
 	case Join:
-		return e.executeJoin(step)
+		return e.executeJoin(ctx, step, state)
 	case HTTP:
-		return e.executeHTTP(step)
+		return e.executeHTTP(ctx, step, state)
 	case Cond:
-		return e.executeCondition(step)
+		return e.executeCondition(ctx, step, state)
+	case Switch:
+		return e.executeSwitch(ctx, step, state)
+	case ForEach:
+		return e.executeForEach(ctx, step, state)
+	case SubDAG:
+		return e.executeSubDAG(ctx, step, state)
+	case Transaction:
+		return e.executeTransaction(ctx, step, state)
 	case Filter:
-		return e.executeFilter(step)
+		return e.executeFilter(ctx, step, state)
+	case Map:
+		return e.executeMap(ctx, step, state)
 	case Output:
-		return e.executeOutput(step)
+		return e.executeOutput(ctx, step, state)
 	default:
 		return nil, fmt.Errorf("unsupported step type: %s", step.Type)
 	}
 }
 
-func (e *Execution) executeOutput(step *Step) (interface{}, error) {
+func (e *Execution) executeOutput(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 	// Find the step with the name specified in step.Source
 	var sourceStep *Step
 	for _, s := range e.stepsMap {
@@ -116,76 +92,115 @@ func (e *Execution) executeOutput(step *Step) (interface{}, error) {
 	}
 
 	// Get the result of the source step
-	sourceResult, ok := (*e.context.Results)[sourceStep.ID]
+	sourceResult, ok := (*state.Results)[sourceStep.ID]
 	if !ok {
 		return nil, fmt.Errorf("result for source step '%s' not found", step.Source)
 	}
-	// value := resolveValues(step.Source, e.context)
-	e.output = sourceResult
+	// value := resolveValues(step.Source, state)
 	return sourceResult, nil
 }
 
-func (e *Execution) executeCondition(step *Step) (interface{}, error) {
+func (e *Execution) executeCondition(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 	left := step.If.Left
 	right := step.If.Right
 	operator := step.Params.If.Operator
 
-	result := eveluateCondition(left, right, operator, e.context)
-	elseStep := step.Else
-	if !result {
-		for _, dep := range elseStep {
-			e.wg.Add(1)
-			go e.initExecution(e.stepsMap[dep])
+	// the scheduler releases the else branch based on this result
+	return eveluateCondition(left, right, operator, state), nil
+}
+
+// executeSwitch returns the switch value together with the index of the
+// matching case, -1 when the default branch is taken
+func (e *Execution) executeSwitch(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	var value interface{}
+	if step.Expression != "" {
+		value = resolveV2[interface{}](step.Expression, state)
+	}
+	for i, c := range step.Cases {
+		if step.Expression == "" {
+			if str, ok := c.When.(string); ok {
+				if matched, _ := resolveV2[interface{}](str, state).(bool); matched {
+					return map[string]interface{}{"value": value, "case": i}, nil
+				}
+			}
+			continue
+		}
+		if eveluateCondition(step.Expression, c.When, EQ, state) {
+			return map[string]interface{}{"value": value, "case": i}, nil
 		}
 	}
-	return nil, nil
+	return map[string]interface{}{"value": value, "case": -1}, nil
 }
 
-func (e *Execution) executeInsert(step *Step) (interface{}, error) {
-	data := resolveValues(step.Params.Map, e.context).(map[string]interface{})
-	return (*e.executor.db).Create(step.Params.Table, data)
+func (e *Execution) executeInsert(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	data := resolveValues(step.Params.Map, state).(map[string]interface{})
+	if err := e.authorize(step, nil, data); err != nil {
+		return nil, err
+	}
+	return e.db.Create(ctx, step.Params.Table, data, step.Returning)
 }
 
-func (e *Execution) executeQuery(step *Step) ([]interface{}, error) {
-	where := resolveValues(step.Params.Where, e.context).(map[string]interface{})
-	return (*e.executor.db).Retrieve(step.Params.Table, step.Params.Select, where)
+func (e *Execution) executeQuery(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	where := resolveValues(step.Params.Where, state).(map[string]interface{})
+	if err := e.authorize(step, where, nil); err != nil {
+		return nil, err
+	}
+	var cursor interface{}
+	if step.After != "" {
+		cursor = resolveV2[interface{}](step.After, state)
+	}
+	page, err := queryPage(step, cursor)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := e.db.Retrieve(ctx, step.Params.Table, step.Params.Select, where, page)
+	if err != nil || page == nil || page.Limit == 0 {
+		return rows, err
+	}
+	return pageResult(page, rows)
 }
 
-func (e *Execution) executeUpdate(step *Step) (interface{}, error) {
-	data := resolveValues(step.Params.Filter, e.context).(map[string]interface{})
-	where := resolveValues(step.Params.Where, e.context).(map[string]interface{})
-	return (*e.executor.db).Update(step.Params.Table, data, where)
+func (e *Execution) executeUpdate(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	data := resolveValues(step.Set, state).(map[string]interface{})
+	where := resolveValues(step.Params.Where, state).(map[string]interface{})
+	if err := e.authorize(step, where, data); err != nil {
+		return nil, err
+	}
+	return e.db.Update(ctx, step.Params.Table, data, where, mutation(step))
 }
 
-func (e *Execution) executeDelete(step *Step) (interface{}, error) {
-	where := resolveValues(step.Params.Where, e.context).(map[string]interface{})
-	return (*e.executor.db).Delete(step.Params.Table, where)
+func (e *Execution) executeDelete(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	where := resolveValues(step.Params.Where, state).(map[string]interface{})
+	if err := e.authorize(step, where, nil); err != nil {
+		return nil, err
+	}
+	return e.db.Delete(ctx, step.Params.Table, where, mutation(step))
 }
 
-func (e *Execution) executeHTTP(step *Step) (interface{}, error) {
+func (e *Execution) executeHTTP(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 
-	query := resolveValues(step.Params.Query, e.context).(map[string]interface{})
-	body := resolveValues(step.Params.Body, e.context).(map[string]interface{})
-	headers := resolveValues(step.Params.Headers, e.context).(map[string]string)
-	url := resolveV2[string](step.Params.URL, e.context)
+	query := resolveValues(step.Params.Query, state).(map[string]interface{})
+	body := resolveValues(step.Params.Body, state).(map[string]interface{})
+	headers := resolveValues(step.Params.Headers, state).(map[string]string)
+	url := resolveV2[string](step.Params.URL, state)
 	switch step.Params.Method {
 	case GET:
-		return (*e.executor.httpClient).Get(url, query, headers)
+		return (*e.executor.httpClient).Get(ctx, url, query, headers)
 	case POST:
-		return (*e.executor.httpClient).Post(url, query, body, headers)
+		return (*e.executor.httpClient).Post(ctx, url, query, body, headers)
 	case PUT:
-		return (*e.executor.httpClient).Put(url, body, query, headers)
+		return (*e.executor.httpClient).Put(ctx, url, body, query, headers)
 	case DELETE:
-		return (*e.executor.httpClient).Delete(url, query, headers)
+		return (*e.executor.httpClient).Delete(ctx, url, query, headers)
 	case PATCH:
-		return (*e.executor.httpClient).Patch(url, body, query, headers)
+		return (*e.executor.httpClient).Patch(ctx, url, body, query, headers)
 	default:
 		return nil, fmt.Errorf("unsupported HTTP method: %s", step.Params.Method)
 
 	}
 }
 
-func (e *Execution) executeJoin(step *Step) (interface{}, error) {
+func (e *Execution) executeJoin(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 	// Get input data
 	var datasets [][]map[string]interface{}
 	if len(step.DependsOn) != 2 {
@@ -198,12 +213,12 @@ func (e *Execution) executeJoin(step *Step) (interface{}, error) {
 		return nil, fmt.Errorf("join step requires right parameter")
 	}
 
-	if v, ok := (*e.context.Results)[step.Params.Left]; ok {
+	if v, ok := (*state.Results)[step.Params.Left]; ok {
 		datasets = append(datasets, v.([]map[string]interface{}))
 	} else {
 		return nil, fmt.Errorf("join step left dependent step %s is not a slice", step.DependsOn[0])
 	}
-	if v, ok := (*e.context.Results)[step.Params.Right]; ok {
+	if v, ok := (*state.Results)[step.Params.Right]; ok {
 		datasets = append(datasets, v.([]map[string]interface{}))
 	} else {
 		return nil, fmt.Errorf("join step right dependent step %s is not a slice", step.DependsOn[1])
@@ -212,13 +227,13 @@ func (e *Execution) executeJoin(step *Step) (interface{}, error) {
 	return performJoin(datasets, step.Params.On, step.Params.Type)
 }
 
-func (e *Execution) executeFilter(step *Step) (interface{}, error) {
+func (e *Execution) executeFilter(ctx context.Context, step *Step, state *Context) (interface{}, error) {
 	// Get input data
 	var dataset []interface{}
 	if len(step.DependsOn) != 1 {
 		return nil, fmt.Errorf("filter step requires exactly one dependent step")
 	}
-	if v, ok := (*e.context.Results)[step.DependsOn[0]]; ok {
+	if v, ok := (*state.Results)[step.DependsOn[0]]; ok {
 		dataset = v.([]interface{})
 	} else {
 		return nil, fmt.Errorf("filter step dependent step %s is not a slice", step.DependsOn[0])
@@ -226,6 +241,36 @@ func (e *Execution) executeFilter(step *Step) (interface{}, error) {
 	return applyFilter(dataset, step.Params.Filter)
 }
 
+func (e *Execution) executeMap(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	// Get input data from the items expression or the single dependency
+	var source interface{}
+	if step.Items != "" {
+		source = resolveV2[interface{}](step.Items, state)
+	} else {
+		if len(step.DependsOn) != 1 {
+			return nil, fmt.Errorf("map step requires items or exactly one dependent step")
+		}
+		v, ok := (*state.Results)[step.DependsOn[0]]
+		if !ok {
+			return nil, fmt.Errorf("map step dependent step %s has no result", step.DependsOn[0])
+		}
+		source = v
+	}
+	rows, err := toRows(source)
+	if err != nil {
+		return nil, fmt.Errorf("map step items: %w", err)
+	}
+	env := map[string]interface{}{
+		"input":   *state.Input,
+		"results": *state.Results,
+		"errors":  *state.Errors,
+	}
+	for name, value := range state.Vars {
+		env[name] = value
+	}
+	return applyMap(rows, step.MapParams, env)
+}
+
 func eveluateCondition(left interface{}, right interface{}, operator Operator, ctx *Context) bool {
 	if v, ok := left.(string); ok {
 		resolvedLeft := resolveV2[interface{}](v, ctx)
@@ -0,0 +1,135 @@
+package dag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxDepth is how deeply DAGs may run other stored DAGs unless the
+// executor is created WithMaxDepth
+const DefaultMaxDepth = 10
+
+var (
+	// ErrNoDAGLoader is returned by steps referring to a stored DAG when the
+	// executor has no DAGLoader
+	ErrNoDAGLoader = errors.New("no DAG loader configured")
+	// ErrDAGCycle is returned when a DAG would end up running itself
+	ErrDAGCycle = errors.New("DAG cycle")
+	// ErrMaxDepth is returned when stored DAGs are nested too deeply
+	ErrMaxDepth = errors.New("maximum DAG depth exceeded")
+)
+
+// executeSubDAG runs the stored DAG the step refers to as a child run with
+// the step's resolved input and returns its output. The child's trace is
+// linked to the step's trace while it runs.
+func (e *Execution) executeSubDAG(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	sub, err := e.loadDAG(ctx, step.DAGID, step.Version)
+	if err != nil {
+		return nil, err
+	}
+	input := resolveValues(step.Input, state).(map[string]interface{})
+	if timeout := parseDuration(sub.Timeout, 0); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrRunTimeout)
+		defer cancel()
+	}
+
+	child, err := e.child(ctx, sub, input, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	child.held = e.holding(step)
+	if e.runID != "" {
+		child.runID = e.runID + "/" + step.ID
+	}
+	e.trace.childStep(step.ID, &ChildRun{
+		RunID:   child.runID,
+		DAGID:   step.DAGID,
+		Version: sub.Version,
+		Trace:   child.trace,
+	})
+
+	result, err := child.execute()
+	if err != nil {
+		return nil, fmt.Errorf("DAG %s: %w", step.DAGID, err)
+	}
+	return result.Output, nil
+}
+
+// loadDAG looks up a stored DAG to run as a child of this execution, refusing
+// DAGs that are already running further up and nesting beyond the maximum depth
+func (e *Execution) loadDAG(ctx context.Context, id string, version int) (*DAG, error) {
+	if e.executor.dags == nil {
+		return nil, ErrNoDAGLoader
+	}
+	for _, running := range e.stack {
+		if running == id {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrDAGCycle, strings.Join(e.stack, " -> "), id)
+		}
+	}
+	if len(e.stack) >= e.executor.maxDepth {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrMaxDepth, strings.Join(e.stack, " -> "), id)
+	}
+
+	sub, err := e.executor.dags.LoadDAG(ctx, id, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DAG %s: %w", id, err)
+	}
+	if errs := Validate(sub); len(errs) > 0 {
+		return nil, fmt.Errorf("DAG %s: %w", id, ValidationErrors(errs))
+	}
+	if err := e.executor.CheckSchema(ctx, sub); err != nil {
+		return nil, fmt.Errorf("DAG %s: %w", id, err)
+	}
+	if sub.ID == "" {
+		sub.ID = id
+	}
+	return sub, nil
+}
+
+// child prepares an execution of a sub-graph within this execution. The
+// child starts out with the given results and has vars in scope. Unless it
+// is the same DAG, such as the inline steps of a foreach step, it counts
+// towards the stack of running DAGs.
+func (e *Execution) child(ctx context.Context, sub *DAG, input map[string]interface{}, inherited map[string]interface{}, vars map[string]interface{}) (*Execution, error) {
+	stepsMap, err := e.executor.mapSteps(sub)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := buildGraph(sub, stepsMap)
+	if err != nil {
+		return nil, err
+	}
+	results := newResults()
+	for id, result := range inherited {
+		results.store(id, result)
+	}
+	stack := e.stack
+	if sub.ID != e.dag.ID {
+		stack = append(append([]string(nil), e.stack...), sub.ID)
+	}
+	access := e.access
+	if sub.Access != nil {
+		access = append(append([]*Access(nil), e.access...), sub.Access)
+	}
+
+	return &Execution{
+		ctx:      ctx,
+		runID:    e.runID,
+		dag:      sub,
+		stepsMap: stepsMap,
+		graph:    graph,
+		input:    input,
+		results:  results,
+		errors:   newResults(),
+		trace:    NewTrace(sub),
+		executor: e.executor,
+		db:       e.db,
+		access:   access,
+		vars:     vars,
+		nested:   true,
+		stack:    stack,
+	}, nil
+}
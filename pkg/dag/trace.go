@@ -0,0 +1,236 @@
+package dag
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	StepCancelled StepStatus = "cancelled"
+	StepSkipped   StepStatus = "skipped"
+)
+
+// Attempt records a single try of a step
+type Attempt struct {
+	Number     int       `json:"number" bson:"number"`
+	StartedAt  time.Time `json:"startedAt" bson:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt" bson:"finishedAt"`
+	StatusCode int       `json:"statusCode,omitempty" bson:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// StepTrace records what happened to a single step during an execution
+type StepTrace struct {
+	StepID     string      `json:"stepId" bson:"stepId"`
+	Status     StepStatus  `json:"status" bson:"status"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	Attempts   []Attempt   `json:"attempts,omitempty" bson:"attempts,omitempty"`
+	Error      string      `json:"error,omitempty" bson:"error,omitempty"`
+	ErrorKind  ErrorKind   `json:"errorKind,omitempty" bson:"errorKind,omitempty"`
+	HandledBy  ErrorAction `json:"handledBy,omitempty" bson:"handledBy,omitempty"` // onError action that absorbed the error
+	SkipReason string      `json:"skipReason,omitempty" bson:"skipReason,omitempty"`
+	QueueWait  int64       `json:"queueWaitMs,omitempty" bson:"queueWaitMs,omitempty"` // ms the ready step waited for free slots
+	Child      *ChildRun   `json:"child,omitempty" bson:"child,omitempty"`             // run of the DAG a dag step executed
+	// Compensation records the compensate step run after a failure of the run
+	Compensation *StepTrace `json:"compensation,omitempty" bson:"compensation,omitempty"`
+}
+
+// ChildRun links the trace of a stored DAG run by a dag step to its parent
+type ChildRun struct {
+	RunID   string `json:"runId,omitempty" bson:"runId,omitempty"`
+	DAGID   string `json:"dagId,omitempty" bson:"dagId,omitempty"` // empty for the block of a transaction step
+	Version int    `json:"version,omitempty" bson:"version,omitempty"`
+	Trace   *Trace `json:"trace" bson:"trace"`
+}
+
+// Trace records the progress of an execution. It is safe to read while the
+// execution is still running.
+type Trace struct {
+	mu         sync.RWMutex
+	StartedAt  time.Time             `json:"startedAt" bson:"startedAt"`
+	FinishedAt *time.Time            `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+	Steps      map[string]*StepTrace `json:"steps" bson:"steps"`
+}
+
+// Result is the output of an execution together with its trace
+type Result struct {
+	Output interface{} `json:"output"`
+	Trace  *Trace      `json:"trace"`
+}
+
+// NewTrace creates a trace with every step of the DAG pending
+func NewTrace(dag *DAG) *Trace {
+	trace := &Trace{
+		StartedAt: time.Now(),
+		Steps:     make(map[string]*StepTrace, len(dag.Steps)),
+	}
+	for _, step := range dag.Steps {
+		trace.Steps[step.ID] = &StepTrace{StepID: step.ID, Status: StepPending}
+	}
+	return trace
+}
+
+func (t *Trace) startStep(stepID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	step := t.Steps[stepID]
+	step.Status = StepRunning
+	step.StartedAt = &now
+}
+
+func (t *Trace) addAttempt(stepID string, attempt Attempt) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	step := t.Steps[stepID]
+	step.Attempts = append(step.Attempts, attempt)
+}
+
+func (t *Trace) finishStep(stepID string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	step := t.Steps[stepID]
+	step.FinishedAt = &now
+	if err != nil {
+		step.Status = StepFailed
+		step.Error = err.Error()
+		step.ErrorKind = ErrorKindOf(err)
+		var stepErr *StepError
+		if errors.As(err, &stepErr) {
+			step.Error = stepErr.Err.Error()
+		}
+		if step.ErrorKind == ErrorCancelled {
+			step.Status = StepCancelled
+		}
+		return
+	}
+	step.Status = StepSucceeded
+}
+
+func (t *Trace) waitStep(stepID string, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Steps[stepID].QueueWait = wait.Milliseconds()
+}
+
+func (t *Trace) skipStep(stepID string, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Steps[stepID].Status = StepSkipped
+	t.Steps[stepID].SkipReason = reason
+}
+
+func (t *Trace) childStep(stepID string, child *ChildRun) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Steps[stepID].Child = child
+}
+
+func (t *Trace) compensateStep(stepID string, compensation *StepTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Steps[stepID].Compensation = compensation
+}
+
+func (t *Trace) handleStep(stepID string, action ErrorAction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Steps[stepID].HandledBy = action
+}
+
+// restore prepares the trace of a resumed execution: restored steps keep
+// their record as succeeded, every other step starts over as pending
+func (t *Trace) restore(dag *DAG, restored map[string]interface{}) {
+	if restored == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.FinishedAt = nil
+	if t.Steps == nil {
+		t.Steps = make(map[string]*StepTrace, len(dag.Steps))
+	}
+	for _, step := range dag.Steps {
+		trace, ok := t.Steps[step.ID]
+		if !ok {
+			trace = &StepTrace{StepID: step.ID}
+			t.Steps[step.ID] = trace
+		}
+		if _, ok := restored[step.ID]; ok {
+			trace.Status = StepSucceeded
+			continue
+		}
+		*trace = StepTrace{StepID: step.ID, Status: StepPending}
+	}
+}
+
+func (t *Trace) finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.FinishedAt = &now
+}
+
+// Step returns a copy of the trace of a single step
+func (t *Trace) Step(stepID string) (StepTrace, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	step, ok := t.Steps[stepID]
+	if !ok {
+		return StepTrace{}, false
+	}
+	return step.copy(), true
+}
+
+// Copy returns a snapshot of the trace that is no longer updated
+func (t *Trace) Copy() *Trace {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	copied := &Trace{
+		StartedAt:  t.StartedAt,
+		FinishedAt: t.FinishedAt,
+		Steps:      make(map[string]*StepTrace, len(t.Steps)),
+	}
+	for id, step := range t.Steps {
+		stepCopy := step.copy()
+		copied.Steps[id] = &stepCopy
+	}
+	return copied
+}
+
+func (s *StepTrace) copy() StepTrace {
+	copied := *s
+	copied.Attempts = append([]Attempt(nil), s.Attempts...)
+	copied.Child = s.Child.copy()
+	if s.Compensation != nil {
+		compensation := s.Compensation.copy()
+		copied.Compensation = &compensation
+	}
+	return copied
+}
+
+func (c *ChildRun) copy() *ChildRun {
+	if c == nil {
+		return nil
+	}
+	copied := *c
+	copied.Trace = c.Trace.Copy()
+	return &copied
+}
+
+func (t *Trace) MarshalJSON() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	type trace Trace
+	return json.Marshal((*trace)(t))
+}
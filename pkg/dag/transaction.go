@@ -0,0 +1,140 @@
+package dag
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// executeTransaction runs the step's inline steps against a single database
+// transaction, committed once every one of them succeeded and rolled back
+// otherwise. The steps see the input and the results of the enclosing DAG.
+// The step result is the output of the block's output step, or the results
+// of all of its steps by ID when it has none.
+func (e *Execution) executeTransaction(ctx context.Context, step *Step, state *Context) (interface{}, error) {
+	begun, err := e.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx, ok := begun.(*serialTx)
+	if !ok {
+		tx = &serialTx{Tx: begun, mu: &sync.Mutex{}}
+	}
+	rollback := func(err error) error {
+		// roll back on a fresh context so a cancelled step does not leave the tx open
+		if rbErr := tx.Rollback(context.WithoutCancel(ctx)); rbErr != nil {
+			return fmt.Errorf("%w (original error: %v)", rbErr, err)
+		}
+		return fmt.Errorf("transaction rolled back: %w", err)
+	}
+
+	block := &DAG{ID: e.dag.ID, InputSchema: e.dag.InputSchema, Steps: step.Steps}
+	child, err := e.child(ctx, block, *state.Input, *state.Results, state.Vars)
+	if err != nil {
+		return nil, rollback(err)
+	}
+	child.db = tx
+	child.held = e.holding(step)
+	if e.runID != "" {
+		child.runID = e.runID + "/" + step.ID
+	}
+	e.trace.childStep(step.ID, &ChildRun{RunID: child.runID, Trace: child.trace})
+
+	err = child.run()
+	child.trace.finish()
+	if err != nil {
+		return nil, rollback(err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, rollback(context.Cause(ctx))
+	}
+
+	var output interface{}
+	if outputStep := outputStep(block); outputStep != nil {
+		output, _ = child.results.load(outputStep.ID)
+		if err := validateSchema(outputStep.Schema, output); err != nil {
+			return nil, rollback(fmt.Errorf("output validation failed: %w", err))
+		}
+	} else {
+		results := make(map[string]interface{}, len(step.Steps))
+		for _, sub := range step.Steps {
+			if result, ok := child.results.load(sub.ID); ok {
+				results[sub.ID] = result
+			}
+		}
+		output = results
+	}
+
+	// a failed commit leaves nothing to roll back
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// serialTx lets the steps of a transaction block run in parallel while their
+// operations take turns on the transaction's single connection
+type serialTx struct {
+	Tx
+	mu *sync.Mutex
+}
+
+func (s *serialTx) Create(ctx context.Context, table string, data map[string]interface{}, returning []string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Create(ctx, table, data, returning)
+}
+
+func (s *serialTx) Retrieve(ctx context.Context, table string, select_ []string, where map[string]interface{}, page *Page) ([]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Retrieve(ctx, table, select_, where, page)
+}
+
+func (s *serialTx) Update(ctx context.Context, table string, data map[string]interface{}, where map[string]interface{}, mutation *Mutation) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Update(ctx, table, data, where, mutation)
+}
+
+func (s *serialTx) Delete(ctx context.Context, table string, where map[string]interface{}, mutation *Mutation) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Delete(ctx, table, where, mutation)
+}
+
+func (s *serialTx) GetTableNames(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.GetTableNames(ctx)
+}
+
+func (s *serialTx) GetColumns(ctx context.Context, table string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.GetColumns(ctx, table)
+}
+
+// Begin nests a savepoint that shares the connection, and so the lock, of
+// the enclosing transaction
+func (s *serialTx) Begin(ctx context.Context) (Tx, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.Tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &serialTx{Tx: tx, mu: s.mu}, nil
+}
+
+func (s *serialTx) Commit(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Commit(ctx)
+}
+
+func (s *serialTx) Rollback(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Tx.Rollback(ctx)
+}
@@ -0,0 +1,721 @@
+package dag
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/parser"
+)
+
+// ValidationError describes a single problem found in a DAG definition
+type ValidationError struct {
+	StepID  string `json:"stepId,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeMissingID         = "missing_id"
+	CodeDuplicateID       = "duplicate_id"
+	CodeUnknownStep       = "unknown_step"
+	CodeCycle             = "cycle"
+	CodeUnreachable       = "unreachable"
+	CodeMissingOutput     = "missing_output"
+	CodeMissingParam      = "missing_param"
+	CodeInvalidParam      = "invalid_param"
+	CodeInvalidExpression = "invalid_expression"
+	CodeNotAncestor       = "not_ancestor"
+	CodeNotAllowed        = "not_allowed"        // the DAG's access rules forbid it
+	CodeUnknownIdentifier = "unknown_identifier" // the database has no such table or column
+)
+
+func (v ValidationError) Error() string {
+	if v.StepID == "" {
+		return v.Message
+	}
+	if v.Field == "" {
+		return fmt.Sprintf("step %s: %s", v.StepID, v.Message)
+	}
+	return fmt.Sprintf("step %s: %s: %s", v.StepID, v.Field, v.Message)
+}
+
+// ValidationErrors is returned when a DAG fails static validation
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid DAG: %s", strings.Join(messages, "; "))
+}
+
+// validator accumulates the problems found while checking a DAG
+type validator struct {
+	dag          *DAG
+	steps        map[string]*Step
+	names        map[string]*Step
+	predecessors map[string][]string
+	successors   map[string][]string
+	outer        map[string]bool // steps of the enclosing DAG a sub-graph may read
+	errs         []ValidationError
+}
+
+// Validate statically checks a DAG before anything is executed
+func Validate(dag *DAG) []ValidationError {
+	return validate(dag, nil)
+}
+
+func validate(dag *DAG, outer map[string]bool) []ValidationError {
+	v := &validator{
+		dag:          dag,
+		steps:        make(map[string]*Step, len(dag.Steps)),
+		names:        make(map[string]*Step, len(dag.Steps)),
+		predecessors: make(map[string][]string),
+		successors:   make(map[string][]string),
+		outer:        outer,
+	}
+	v.collectSteps()
+	v.collectEdges()
+	cyclic := v.checkCycles()
+	v.checkReachability(cyclic)
+	v.checkOutput()
+	v.checkDuration("", "timeout", dag.Timeout)
+	if dag.MaxParallel < 0 {
+		v.add("", "maxParallel", CodeInvalidParam, "maxParallel must not be negative")
+	}
+	v.checkAccessRules()
+	for i := range dag.Steps {
+		step := &dag.Steps[i]
+		v.checkParams(step)
+		v.checkAccess(step)
+		v.checkExpressions(step)
+		v.checkRetry(step)
+		v.checkOnError(step)
+		v.checkTriggerRule(step)
+		v.checkVersion(step)
+		v.checkCompensate(step)
+		v.checkDuration(step.ID, "timeout", step.Timeout)
+	}
+	return v.errs
+}
+
+func (v *validator) add(stepID, field, code, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		StepID:  stepID,
+		Field:   field,
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) collectSteps() {
+	for i := range v.dag.Steps {
+		step := &v.dag.Steps[i]
+		if step.ID == "" {
+			v.add("", fmt.Sprintf("steps[%d].id", i), CodeMissingID, "step at index %d has no id", i)
+			continue
+		}
+		if _, ok := v.steps[step.ID]; ok {
+			v.add(step.ID, "id", CodeDuplicateID, "duplicate step id %s", step.ID)
+			continue
+		}
+		v.steps[step.ID] = step
+		if step.Name != "" {
+			v.names[step.Name] = step
+		}
+	}
+}
+
+func (v *validator) collectEdges() {
+	seen := make(map[[2]string]bool)
+	addEdge := func(stepID, field, from, to string) {
+		ref := to
+		if ref == stepID {
+			ref = from
+		}
+		if _, ok := v.steps[ref]; !ok {
+			v.add(stepID, field, CodeUnknownStep, "references unknown step %s", ref)
+			return
+		}
+		if seen[[2]string{from, to}] {
+			return
+		}
+		seen[[2]string{from, to}] = true
+		v.successors[from] = append(v.successors[from], to)
+		v.predecessors[to] = append(v.predecessors[to], from)
+	}
+	for _, step := range v.dag.Steps {
+		if v.steps[step.ID] == nil {
+			continue
+		}
+		for _, next := range step.Then {
+			addEdge(step.ID, "then", step.ID, next)
+		}
+		for _, next := range step.Else {
+			addEdge(step.ID, "else", step.ID, next)
+		}
+		for i, c := range step.Cases {
+			for _, next := range c.Then {
+				addEdge(step.ID, fmt.Sprintf("cases[%d].then", i), step.ID, next)
+			}
+		}
+		for _, next := range step.Default {
+			addEdge(step.ID, "default", step.ID, next)
+		}
+		for _, dep := range step.DependsOn {
+			addEdge(step.ID, "dependsOn", dep, step.ID)
+		}
+		if step.OnError != nil {
+			for _, next := range step.OnError.Goto {
+				addEdge(step.ID, "onError.goto", step.ID, next)
+			}
+		}
+	}
+}
+
+// checkCycles reports every cycle once and returns the steps that are part of one
+func (v *validator) checkCycles() map[string]bool {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(v.steps))
+	cyclic := make(map[string]bool)
+	path := make([]string, 0)
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, next := range v.successors[id] {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case visiting:
+				start := 0
+				for i, p := range path {
+					if p == next {
+						start = i
+						break
+					}
+				}
+				cycle := append(append([]string{}, path[start:]...), next)
+				for _, p := range cycle {
+					cyclic[p] = true
+				}
+				v.add(next, "", CodeCycle, "cycle detected: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+	for _, step := range v.dag.Steps {
+		if v.steps[step.ID] != nil && state[step.ID] == unvisited {
+			visit(step.ID)
+		}
+	}
+	return cyclic
+}
+
+// checkReachability reports steps that no entry step can ever lead to
+func (v *validator) checkReachability(cyclic map[string]bool) {
+	reached := make(map[string]bool, len(v.steps))
+	queue := make([]string, 0)
+	for _, step := range v.dag.Steps {
+		if v.steps[step.ID] != nil && len(v.predecessors[step.ID]) == 0 {
+			reached[step.ID] = true
+			queue = append(queue, step.ID)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, next := range v.successors[id] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	for _, step := range v.dag.Steps {
+		if v.steps[step.ID] != nil && !reached[step.ID] && !cyclic[step.ID] {
+			v.add(step.ID, "", CodeUnreachable, "step can never be scheduled")
+		}
+	}
+}
+
+func (v *validator) checkOutput() {
+	if outputStep(v.dag) == nil {
+		v.add("", "steps", CodeMissingOutput, "DAG has no output step")
+	}
+}
+
+// ancestors returns every step that transitively precedes stepID
+func (v *validator) ancestors(stepID string) map[string]bool {
+	ancestors := make(map[string]bool)
+	queue := append([]string{}, v.predecessors[stepID]...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if ancestors[id] {
+			continue
+		}
+		ancestors[id] = true
+		queue = append(queue, v.predecessors[id]...)
+	}
+	return ancestors
+}
+
+func (v *validator) checkParams(step *Step) {
+	require := func(field string, ok bool) {
+		if !ok {
+			v.add(step.ID, field, CodeMissingParam, "%s step requires %s", step.Type, field)
+		}
+	}
+	requireAncestor := func(field string, ref string) {
+		if ref == "" {
+			return
+		}
+		if _, ok := v.steps[ref]; !ok {
+			v.add(step.ID, field, CodeUnknownStep, "references unknown step %s", ref)
+		} else if !v.ancestors(step.ID)[ref] {
+			v.add(step.ID, field, CodeNotAncestor, "step %s does not run before this step", ref)
+		}
+	}
+
+	switch step.Type {
+	case Query:
+		require("table", step.Table != "")
+		v.checkPage(step)
+	case Delete:
+		require("table", step.Table != "")
+		v.checkMutation(step)
+	case Insert:
+		require("table", step.Table != "")
+		require("map", len(step.Params.Map) > 0)
+		v.checkReturning(step)
+	case Update:
+		require("table", step.Table != "")
+		require("set", len(step.Set) > 0)
+		v.checkMutation(step)
+	case Join:
+		require("left", step.Left != "")
+		require("right", step.Right != "")
+		require("on", len(step.On) > 0)
+		if len(step.DependsOn) != 2 {
+			v.add(step.ID, "dependsOn", CodeInvalidParam, "join step requires exactly two dependent steps")
+		}
+		switch step.Params.Type {
+		case Inner, Left, Right, "":
+		default:
+			v.add(step.ID, "joinType", CodeInvalidParam, "unsupported join type %q", step.Params.Type)
+		}
+		requireAncestor("left", step.Left)
+		requireAncestor("right", step.Right)
+	case Filter:
+		if len(step.DependsOn) != 1 {
+			v.add(step.ID, "dependsOn", CodeInvalidParam, "filter step requires exactly one dependent step")
+		}
+	case Cond:
+		switch step.If.Operator {
+		case EQ, NE, GT, GTE, LT, LTE, IN, NOTIN, AND, OR:
+		default:
+			v.add(step.ID, "if.operator", CodeInvalidParam, "unsupported operator %q", step.If.Operator)
+		}
+	case Switch:
+		require("cases", len(step.Cases) > 0)
+		for i, c := range step.Cases {
+			if _, ok := c.When.(string); step.Expression == "" && !ok {
+				v.add(step.ID, fmt.Sprintf("cases[%d].when", i), CodeInvalidParam, "when must be an expression when the switch has none")
+			}
+		}
+	case ForEach:
+		require("items", step.Items != "")
+		if (len(step.Steps) == 0) == (step.DAGID == "") {
+			v.add(step.ID, "steps", CodeInvalidParam, "foreach step requires exactly one of steps or dagId")
+		}
+		if step.Concurrency < 0 {
+			v.add(step.ID, "concurrency", CodeInvalidParam, "concurrency must not be negative")
+		}
+		if step.Input != nil && step.DAGID == "" {
+			v.add(step.ID, "input", CodeInvalidParam, "input only applies to a foreach step running a stored DAG")
+		}
+		v.checkSubSteps(step)
+	case SubDAG:
+		require("dagId", step.DAGID != "")
+	case Transaction:
+		require("steps", len(step.Steps) > 0)
+		v.checkSubSteps(step)
+	case HTTP:
+		require("url", step.URL != "")
+		switch step.Method {
+		case GET, POST, PUT, DELETE, PATCH:
+		default:
+			v.add(step.ID, "method", CodeInvalidParam, "unsupported HTTP method %q", step.Method)
+		}
+	case Map:
+		if (step.Function == "") == (len(step.Fields) == 0) {
+			v.add(step.ID, "function", CodeInvalidParam, "map step requires exactly one of function or fields")
+		}
+		if step.Items == "" && len(step.DependsOn) != 1 {
+			v.add(step.ID, "items", CodeInvalidParam, "map step requires items or exactly one dependent step")
+		}
+	case Output:
+		require("source", step.Source != "")
+		if source, ok := v.names[step.Source]; step.Source != "" && !ok {
+			v.add(step.ID, "source", CodeUnknownStep, "no step is named %s", step.Source)
+		} else if ok {
+			requireAncestor("source", source.ID)
+		}
+	default:
+		v.add(step.ID, "type", CodeInvalidParam, "unsupported step type %q", step.Type)
+	}
+}
+
+// checkPage checks the ordering and paging of a query step
+func (v *validator) checkPage(step *Step) {
+	for i, order := range step.OrderBy {
+		if order.Column == "" {
+			v.add(step.ID, fmt.Sprintf("orderBy[%d].column", i), CodeMissingParam, "orderBy requires a column")
+		}
+	}
+	if step.Limit < 0 {
+		v.add(step.ID, "limit", CodeInvalidParam, "limit must not be negative")
+	}
+	if step.Offset < 0 {
+		v.add(step.ID, "offset", CodeInvalidParam, "offset must not be negative")
+	}
+	if step.After != "" && len(step.OrderBy) == 0 {
+		v.add(step.ID, "after", CodeMissingParam, "after requires orderBy")
+	}
+}
+
+// checkAccessRules checks the operations the access rules of the DAG allow
+func (v *validator) checkAccessRules() {
+	if v.dag.Access == nil || v.outer != nil {
+		return
+	}
+	for i, op := range v.dag.Access.Operations {
+		if !isDatabaseStep(op) {
+			v.add("", fmt.Sprintf("access.operations[%d]", i), CodeInvalidParam, "%s is not a database operation", op)
+		} else if v.dag.Access.ReadOnly && op != Query {
+			v.add("", fmt.Sprintf("access.operations[%d]", i), CodeInvalidParam, "a read-only DAG cannot allow %s", op)
+		}
+	}
+}
+
+// checkAccess checks a database step against the access rules of the DAG.
+// Columns named by resolved values are only known, and checked, at run time.
+func (v *validator) checkAccess(step *Step) {
+	access := v.dag.Access
+	if access == nil || !isDatabaseStep(step.Type) {
+		return
+	}
+	if err := access.checkOperation(step.Type); err != nil {
+		v.add(step.ID, "type", CodeNotAllowed, "%v", err)
+		return
+	}
+	if err := access.checkColumns(step.Table, nil); err != nil {
+		v.add(step.ID, "table", CodeNotAllowed, "%v", err)
+		return
+	}
+	for _, field := range declaredIdentifiers(step) {
+		if err := access.checkColumns(step.Table, field.columns); err != nil {
+			v.add(step.ID, field.field, CodeNotAllowed, "%v", err)
+		}
+	}
+}
+
+// checkMutation checks the bounds of an update or delete step
+func (v *validator) checkMutation(step *Step) {
+	if len(step.Where) == 0 && !step.AllowAll {
+		v.add(step.ID, "where", CodeMissingParam, "%s step requires where, or allowAll to change every row", step.Type)
+	} else if err := mutation(step).CheckWhere(step.Where); err != nil {
+		v.add(step.ID, "where", CodeInvalidParam, "%v", err)
+	}
+	if step.MaxAffectedRows < 0 {
+		v.add(step.ID, "maxAffectedRows", CodeInvalidParam, "maxAffectedRows must not be negative")
+	}
+	v.checkReturning(step)
+}
+
+// checkReturning checks the columns an insert, update or delete step returns
+func (v *validator) checkReturning(step *Step) {
+	for i, column := range step.Returning {
+		if column == "" {
+			v.add(step.ID, fmt.Sprintf("returning[%d]", i), CodeInvalidParam, "returning requires column names")
+		}
+	}
+}
+
+// checkSubSteps validates the inline sub-graph of a foreach or transaction
+// step. Its steps may read the results of the steps running before the step
+// but must not reuse their IDs, since they share the same results. The block
+// of a transaction step needs no output step.
+func (v *validator) checkSubSteps(step *Step) {
+	if len(step.Steps) == 0 {
+		return
+	}
+	outer := v.ancestors(step.ID)
+	for id := range v.outer {
+		outer[id] = true
+	}
+	for i, sub := range step.Steps {
+		if _, ok := v.steps[sub.ID]; ok || v.outer[sub.ID] {
+			v.add(step.ID, fmt.Sprintf("steps[%d].id", i), CodeDuplicateID, "sub-step id %s is already used by the enclosing DAG", sub.ID)
+		}
+	}
+	for _, err := range validate(&DAG{Steps: step.Steps, Access: v.dag.Access}, outer) {
+		if err.Code == CodeMissingOutput && step.Type == Transaction {
+			continue
+		}
+		if err.StepID == "" {
+			err.StepID = step.ID
+		} else {
+			err.StepID = step.ID + "." + err.StepID
+		}
+		v.errs = append(v.errs, err)
+	}
+}
+
+// checkCompensate validates the compensate step of a step, which may read the
+// results of the step itself and of the steps running before it
+func (v *validator) checkCompensate(step *Step) {
+	if step.Compensate == nil {
+		return
+	}
+	outer := v.ancestors(step.ID)
+	outer[step.ID] = true
+	for id := range v.outer {
+		outer[id] = true
+	}
+	compensation := *step.Compensate
+	compensation.ID = step.ID + ".compensate"
+	if len(compensation.Then) > 0 || len(compensation.DependsOn) > 0 {
+		v.add(step.ID, "compensate", CodeInvalidParam, "compensate step cannot have then or dependsOn")
+		compensation.Then, compensation.DependsOn = nil, nil
+	}
+	for _, err := range validate(&DAG{Steps: []Step{compensation}, Access: v.dag.Access}, outer) {
+		if err.Code == CodeMissingOutput {
+			continue
+		}
+		v.errs = append(v.errs, err)
+	}
+}
+
+func (v *validator) checkRetry(step *Step) {
+	policy := step.Retry
+	if policy == nil {
+		return
+	}
+	if policy.MaxAttempts < 1 {
+		v.add(step.ID, "retry.maxAttempts", CodeInvalidParam, "maxAttempts must be at least 1")
+	}
+	v.checkDuration(step.ID, "retry.initialDelay", policy.InitialDelay)
+	v.checkDuration(step.ID, "retry.maxDelay", policy.MaxDelay)
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		v.add(step.ID, "retry.multiplier", CodeInvalidParam, "multiplier must be at least 1")
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		v.add(step.ID, "retry.jitter", CodeInvalidParam, "jitter must be between 0 and 1")
+	}
+	if len(policy.RetryOnStatus) > 0 && step.Type != HTTP {
+		v.add(step.ID, "retry.retryOnStatus", CodeInvalidParam, "retryOnStatus only applies to http steps")
+	}
+}
+
+func (v *validator) checkOnError(step *Step) {
+	policy := step.OnError
+	if policy == nil {
+		return
+	}
+	switch policy.Action {
+	case "", OnErrorFail, OnErrorContinue, OnErrorFallback:
+	case OnErrorGoto:
+		if len(policy.Goto) == 0 {
+			v.add(step.ID, "onError.goto", CodeMissingParam, "goto policy requires at least one catch step")
+		}
+	default:
+		v.add(step.ID, "onError.action", CodeInvalidParam, "unsupported onError action %q", policy.Action)
+	}
+	if len(policy.Goto) > 0 && policy.Action != OnErrorGoto {
+		v.add(step.ID, "onError.goto", CodeInvalidParam, "goto steps are only used with the goto action")
+	}
+}
+
+func (v *validator) checkVersion(step *Step) {
+	if step.Version < 0 {
+		v.add(step.ID, "version", CodeInvalidParam, "version must not be negative")
+	} else if step.Version > 0 && step.DAGID == "" {
+		v.add(step.ID, "version", CodeInvalidParam, "version only applies to a stored DAG")
+	}
+}
+
+func (v *validator) checkTriggerRule(step *Step) {
+	switch step.TriggerRule {
+	case "", TriggerAllSuccess, TriggerOneSuccess, TriggerAllDone, TriggerNoneFailed:
+	default:
+		v.add(step.ID, "triggerRule", CodeInvalidParam, "unsupported trigger rule %q", step.TriggerRule)
+	}
+}
+
+func (v *validator) checkDuration(stepID, field, value string) {
+	if value == "" {
+		return
+	}
+	if d, err := time.ParseDuration(value); err != nil || d < 0 {
+		v.add(stepID, field, CodeInvalidParam, "invalid duration %q", value)
+	}
+}
+
+// checkExpressions parses every $ and ${} expression in the step params and
+// makes sure $results references only point at steps that run earlier
+func (v *validator) checkExpressions(step *Step) {
+	var ancestors map[string]bool
+	checkSource := func(field, str, src string) {
+		tree, err := parser.Parse(src)
+		if err != nil {
+			v.add(step.ID, field, CodeInvalidExpression, "cannot parse %q: %v", str, err)
+			return
+		}
+		if ancestors == nil {
+			ancestors = v.ancestors(step.ID)
+		}
+		for _, ref := range resultReferences(tree.Node) {
+			if _, ok := v.steps[ref]; !ok && v.outer[ref] {
+				continue
+			}
+			if _, ok := v.steps[ref]; !ok {
+				v.add(step.ID, field, CodeUnknownStep, "%q references unknown step %s", str, ref)
+			} else if !ancestors[ref] {
+				v.add(step.ID, field, CodeNotAncestor, "%q reads step %s which does not run before this step", str, ref)
+			}
+		}
+	}
+	check := func(field string, value interface{}) {
+		walkStrings(value, func(str string) {
+			for _, src := range expressions(str) {
+				checkSource(field, str, src)
+			}
+		})
+	}
+
+	check("where", step.Where)
+	check("after", step.After)
+	check("map", step.Params.Map)
+	check("set", step.Set)
+	check("url", step.URL)
+	check("headers", step.Headers)
+	check("body", step.Body)
+	check("query", step.Query)
+	check("if.left", step.If.Left)
+	check("if.right", step.If.Right)
+	check("items", step.Items)
+	check("expression", step.Expression)
+	check("input", step.Input)
+	for i, c := range step.Cases {
+		check(fmt.Sprintf("cases[%d].when", i), c.When)
+	}
+	if step.OnError != nil {
+		check("onError.fallback", step.OnError.Fallback)
+	}
+
+	// map expressions are evaluated per row and do not need the $ prefix
+	if step.Function != "" {
+		checkSource("function", step.Function, strings.TrimPrefix(step.Function, "$"))
+	}
+	for field, src := range step.Fields {
+		checkSource("fields."+field, src, strings.TrimPrefix(src, "$"))
+	}
+}
+
+// expressions extracts the expression sources from a string using the same
+// rules as resolveV2: ${} templates first, otherwise a leading $
+func expressions(str string) []string {
+	if strings.Contains(str, "${") {
+		sources := make([]string, 0)
+		rest := str
+		for {
+			start := strings.Index(rest, "${")
+			if start == -1 {
+				break
+			}
+			end := strings.Index(rest[start:], "}")
+			if end == -1 {
+				sources = append(sources, rest[start+2:])
+				break
+			}
+			sources = append(sources, rest[start+2:start+end])
+			rest = rest[start+end+1:]
+		}
+		return sources
+	}
+	if strings.HasPrefix(str, "$") {
+		return []string{str[1:]}
+	}
+	return nil
+}
+
+// walkStrings calls fn for every string nested in value
+func walkStrings(value interface{}, fn func(string)) {
+	switch v := value.(type) {
+	case string:
+		fn(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case map[string]string:
+		for _, item := range v {
+			fn(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	case []map[string]interface{}:
+		for _, item := range v {
+			walkStrings(item, fn)
+		}
+	}
+}
+
+// resultVisitor collects the X of every results.X member access
+type resultVisitor struct {
+	refs []string
+}
+
+func (r *resultVisitor) Visit(node *ast.Node) {
+	member, ok := (*node).(*ast.MemberNode)
+	if !ok {
+		return
+	}
+	ident, ok := member.Node.(*ast.IdentifierNode)
+	if !ok || ident.Value != "results" {
+		return
+	}
+	if prop, ok := member.Property.(*ast.StringNode); ok {
+		r.refs = append(r.refs, prop.Value)
+	}
+}
+
+func resultReferences(node ast.Node) []string {
+	visitor := &resultVisitor{}
+	ast.Walk(&node, visitor)
+	return visitor.refs
+}
+
+// outputStep returns the step whose result becomes the DAG output
+func outputStep(dag *DAG) *Step {
+	for i := range dag.Steps {
+		if dag.Steps[i].Type == Output || dag.Steps[i].Name == "output" {
+			return &dag.Steps[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package dag
+
+import (
+	"testing"
+)
+
+// validDAG returns a DAG that passes validation: a query of users feeding an
+// update and the output
+func validDAG() *DAG {
+	update := Step{ID: "update", Type: Update, DependsOn: []string{"users"}, Params: Params{DbOperationParams: DbOperationParams{
+		Table: "users",
+		Where: map[string]interface{}{"id": "$results.users[0].id"},
+	}}}
+	update.Set = map[string]interface{}{"status": "seen"}
+	output := Step{ID: "output", Type: Output, DependsOn: []string{"users"}, Params: Params{OutputParams: OutputParams{Source: "users", Schema: Schema{Type: "array"}}}}
+	return &DAG{ID: "valid", InputSchema: Schema{Type: "object"}, Steps: []Step{query("users", "users"), update, output}}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(d *DAG)
+		stepID string
+		field  string
+		code   string
+	}{
+		{name: "valid", change: func(d *DAG) {}},
+		{
+			name:   "duplicate id",
+			change: func(d *DAG) { d.Steps[1].ID = "users" },
+			stepID: "users", field: "id", code: CodeDuplicateID,
+		},
+		{
+			name:   "missing id",
+			change: func(d *DAG) { d.Steps[1].ID = "" },
+			field:  "steps[1].id", code: CodeMissingID,
+		},
+		{
+			name:   "unknown step",
+			change: func(d *DAG) { d.Steps[0].Then = []string{"missing"} },
+			stepID: "users", field: "then", code: CodeUnknownStep,
+		},
+		{
+			name:   "cycle",
+			change: func(d *DAG) { d.Steps[0].DependsOn = []string{"update"} },
+			code:   CodeCycle,
+		},
+		{
+			name:   "missing output",
+			change: func(d *DAG) { d.Steps = d.Steps[:2] },
+			field:  "steps", code: CodeMissingOutput,
+		},
+		{
+			name:   "missing table",
+			change: func(d *DAG) { d.Steps[0].Table = "" },
+			stepID: "users", field: "table", code: CodeMissingParam,
+		},
+		{
+			name:   "unparseable expression",
+			change: func(d *DAG) { d.Steps[1].Where["id"] = "$results.users[0" },
+			stepID: "update", field: "where", code: CodeInvalidExpression,
+		},
+		{
+			name: "result of a later step",
+			change: func(d *DAG) {
+				d.Steps[1].DependsOn = nil
+				d.Steps[1].Then = nil
+			},
+			stepID: "update", field: "where", code: CodeNotAncestor,
+		},
+		{
+			name: "unsupported join type",
+			change: func(d *DAG) {
+				d.Steps[1] = Step{ID: "update", Type: Join, DependsOn: []string{"users", "output"}, Params: Params{JoinParams: JoinParams{Type: "outer"}}}
+			},
+			stepID: "update", field: "joinType", code: CodeInvalidParam,
+		},
+		{
+			name:   "update without where",
+			change: func(d *DAG) { d.Steps[1].Where = nil },
+			stepID: "update", field: "where", code: CodeMissingParam,
+		},
+		{
+			name: "update with an empty notin",
+			change: func(d *DAG) {
+				d.Steps[1].Where = map[string]interface{}{"id": map[string]interface{}{"notin": []interface{}{}}}
+			},
+			stepID: "update", field: "where", code: CodeInvalidParam,
+		},
+		{
+			name:   "negative limit",
+			change: func(d *DAG) { d.Steps[0].Limit = -1 },
+			stepID: "users", field: "limit", code: CodeInvalidParam,
+		},
+		{
+			name:   "after without orderBy",
+			change: func(d *DAG) { d.Steps[0].After = "$input.cursor" },
+			stepID: "users", field: "after", code: CodeMissingParam,
+		},
+		{
+			name:   "no retry attempts",
+			change: func(d *DAG) { d.Steps[0].Retry = &RetryPolicy{} },
+			stepID: "users", field: "retry.maxAttempts", code: CodeInvalidParam,
+		},
+		{
+			name:   "unsupported onError action",
+			change: func(d *DAG) { d.Steps[0].OnError = &ErrorPolicy{Action: "ignore"} },
+			stepID: "users", field: "onError.action", code: CodeInvalidParam,
+		},
+		{
+			name:   "read-only access",
+			change: func(d *DAG) { d.Access = &Access{ReadOnly: true} },
+			stepID: "update", field: "type", code: CodeNotAllowed,
+		},
+		{
+			name:   "table not allowed",
+			change: func(d *DAG) { d.Access = &Access{Tables: map[string][]string{"orders": nil}} },
+			stepID: "users", field: "table", code: CodeNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := validDAG()
+			tt.change(d)
+			errs := Validate(d)
+			if tt.code == "" {
+				if len(errs) > 0 {
+					t.Fatalf("got %v, want no errors", ValidationErrors(errs))
+				}
+				return
+			}
+			for _, err := range errs {
+				if err.Code == tt.code && (tt.stepID == "" || err.StepID == tt.stepID) && (tt.field == "" || err.Field == tt.field) {
+					return
+				}
+			}
+			t.Fatalf("got %v, want %s on %s %s", ValidationErrors(errs), tt.code, tt.stepID, tt.field)
+		})
+	}
+}